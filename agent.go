@@ -2,12 +2,13 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -20,160 +21,85 @@ func runAgent(cfg *LoadedConfig) {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	mc, err := setupMqtt(cfg)
+	err := agent(ctx, cfg)
 	if err != nil {
-		slog.Error("mqtt setup", "err", err)
+		slog.Error("agent", "err", err)
 		os.Exit(1)
 	}
-	defer mc.Disconnect(2000)
-
-	var inverter *solar.Client
-
-	connectToInverter := func() error {
-		if inverter != nil {
-			inverter.Close()
-			inverter = nil
-		}
+}
 
-		var err error
+// agent polls every inverter until ctx is done, only returning early if the sinks or mqtt can't be set up
+func agent(ctx context.Context, cfg *LoadedConfig) error {
+	metrics := newAgentMetrics()
+	if cfg.Metrics.Listen != "" {
+		go metrics.serve(ctx, cfg.Metrics.Listen)
+	}
 
-		dialCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-		defer cancel()
+	// Bumped whenever we (re)connect to the broker, so discovery is re-sent with the next sample from each device
+	var haDiscoveryGen atomic.Uint64
+	avail := newAvailability(cfg)
 
-		inverter, err = setupInverter(dialCtx, cfg)
-		if err != nil {
-			slog.Error("failed to connect to inverter", "err", err)
-			return err
-		}
+	// MQTT->Inverter command channels, one per poller
+	cmdChs := make(map[*inverter]chan commandRequest)
+	for _, inv := range cfg.inverters {
+		cmdChs[inv] = make(chan commandRequest, 10)
+	}
 
-		err = inverter.BroadcastHello(cfg.broadcastDstIP, cfg.broadcastSelfIP)
+	// MQTT is optional, as long as none of the sinks need it
+	var mc mqtt.Client
+	if cfg.MQTT.Broker != "" {
+		var err error
+		mc, err = setupMqtt(cfg, func(c mqtt.Client) {
+			slog.Info("connected to mqtt broker")
+			haDiscoveryGen.Add(1)
+			avail.publishAll(c)
+			subscribeCommands(c, cfg, cmdChs)
+		})
 		if err != nil {
-			slog.Warn("problem when trying to broadcast hello message, proceeding anyway (normal when across VLANs/subnets)", "err", err)
+			return fmt.Errorf("mqtt setup: %v", err)
 		}
-
-		go inverter.Run(ctx)
-		return nil
+		avail.mc = mc
+		defer func() {
+			avail.publishOffline()
+			mc.Disconnect(2000)
+		}()
 	}
 
-	err = connectToInverter()
+	sinks, err := setupSinks(cfg, mc, &haDiscoveryGen, metrics)
 	if err != nil {
-		slog.Error("failed to connect to inverter", "err", err)
-		os.Exit(1)
+		return fmt.Errorf("sink setup: %v", err)
 	}
-
-	err = inverter.Login(ctx, cfg.Modbus.Username, cfg.Modbus.Password)
-	if err != nil {
-		slog.Warn("problem when trying to log in to inverter, proceeding anyway", "err", err)
-	} else {
-		slog.Info("successfully logged in")
+	for _, sink := range sinks {
+		go sink.run(ctx)
 	}
 
-	handleQueryError := func(err error) {
-		slog.Warn("query error", "err", err)
-		slog.Info("attempting to login again (likely timed out)")
-
-		err = inverter.Login(ctx, cfg.Modbus.Username, cfg.Modbus.Password)
-		if err == nil {
-			slog.Info("successfully logged in again")
-			return
-		}
+	var wg sync.WaitGroup
+	for _, inv := range cfg.inverters {
+		p := newPoller(cfg, inv, mc, avail, metrics, sinks, cmdChs[inv])
 
-		slog.Warn("failed to complete login again, restarting connection to inverter", "err", err)
-
-		err = connectToInverter()
-		backoff := time.Second
-
-		attempts := 0
-		for err != nil {
-			slog.Warn("failed to connect to inverter", "err", err, "attempts", attempts, "retrying_in", backoff.Seconds())
-			time.Sleep(backoff)
-			err = connectToInverter()
-
-			if backoff < (5 * time.Minute) {
-				attempts++
-				if attempts >= 10 {
-					backoff *= 2
-					attempts = 0
-				}
-			}
-		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.supervise(ctx)
+		}()
 	}
 
-	// Inverter->MQTT message channel
-	dataCh := make(chan *solar.Data, 10)
-
-	// Query goroutine
-	go func() {
-		ticker := time.NewTicker(cfg.interval)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-
-			case <-ticker.C:
-				if cfg.LogQuery {
-					slog.Info("querying...")
-				}
-
-				d, err := inverter.Query(ctx)
-
-				if err != nil {
-					handleQueryError(err)
-					continue
-				}
-
-				if cfg.LogQuery {
-					slog.Info("query data", "data", d.Pretty())
-				}
-
-				select {
-				case dataCh <- d:
-				default:
-					slog.Warn("data channel full, dropping sample (mqtt client sad?)")
-				}
-			}
-		}
-	}()
-
-	// Publisher goroutine
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-
-			case d := <-dataCh:
-				if d == nil {
-					continue
-				}
-
-				payload, err := json.Marshal(d)
-				if err != nil {
-					slog.Warn("marshal error when sending mqtt json", "err", err)
-					continue
-				}
-
-				token := mc.Publish(cfg.MQTT.Topic, cfg.MQTT.QoS, cfg.MQTT.Retain, payload)
-				if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
-					slog.Warn("mqtt publish error", "err", token.Error())
-				}
-			}
-		}
-	}()
-
 	// Block until signal
 	<-ctx.Done()
 	slog.Info("exiting")
+	wg.Wait()
+	return nil
 }
 
-func setupMqtt(cfg *LoadedConfig) (mqtt.Client, error) {
+func setupMqtt(cfg *LoadedConfig, onConnect mqtt.OnConnectHandler) (mqtt.Client, error) {
 	mopts := mqtt.NewClientOptions().AddBroker(cfg.MQTT.Broker).SetClientID(cfg.MQTT.ClientID)
 	if cfg.MQTT.Username != "" {
 		mopts.SetUsername(cfg.MQTT.Username)
 		mopts.SetPassword(cfg.MQTT.Password)
 	}
 	mopts.SetAutoReconnect(true).SetConnectRetry(true).SetConnectTimeout(5 * time.Second)
+	mopts.SetOnConnectHandler(onConnect)
+	mopts.SetWill(cfg.MQTT.AvailabilityTopic, payloadOffline, cfg.MQTT.QoS, true)
 
 	mc := mqtt.NewClient(mopts)
 	token := mc.Connect()
@@ -183,12 +109,32 @@ func setupMqtt(cfg *LoadedConfig) (mqtt.Client, error) {
 	return mc, nil
 }
 
-func setupInverter(ctx context.Context, cfg *LoadedConfig) (*solar.Client, error) {
+func setupInverter(ctx context.Context, inv *inverter) (*solar.Client, error) {
 	var dialer net.Dialer
-	conn, err := dialer.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", cfg.Modbus.IP, cfg.Modbus.Port))
+	conn, err := dialer.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", inv.modbus.IP, inv.modbus.Port))
 	if err != nil {
 		return nil, fmt.Errorf("failed to dial modbus tcp: %v", err)
 	}
 
-	return solar.NewClient(modbus.NewModbusConn(conn, cfg.Modbus.SlaveID)), nil
+	mc := modbus.NewModbusConn(conn, inv.modbus.slaveID())
+	if inv.modbus.ReadGap != nil {
+		mc.SetReadGap(*inv.modbus.ReadGap)
+	}
+
+	client := solar.NewClient(mc)
+	client.SetSkipInverterVerify(inv.modbus.SkipInverterVerify != nil && *inv.modbus.SkipInverterVerify)
+	return client, nil
+}
+
+// querySample reads the device's register map if it has one, otherwise the registers built in to solar.Data
+func querySample(ctx context.Context, dev *device, inverter *solar.Client) (*solar.Sample, error) {
+	if dev.registerMap != nil {
+		return inverter.QueryRegisterMap(ctx, dev.registerMap)
+	}
+
+	d, err := inverter.Query(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return d.Sample(), nil
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/mock"
+)
+
+// TestAgent runs the whole agent against the mock inverter, with a file sink instead of a broker
+func TestAgent(t *testing.T) {
+	srv := mock.NewServer()
+	srv.Username, srv.Password = "user", "pw"
+	srv.RequireLogin = true
+	if err := srv.SetRegisters(mock.DefaultRegisters()); err != nil {
+		t.Fatalf("set registers: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srvCtx, stopSrv := context.WithCancel(context.Background())
+	defer stopSrv()
+	go srv.Serve(srvCtx, ln)
+
+	out := filepath.Join(t.TempDir(), "samples.jsonl")
+	port := ln.Addr().(*net.TCPAddr).Port
+	cfg, err := parseTestConfig(t, fmt.Sprintf(`
+modbus: {ip: 127.0.0.1, port: %d, slave_id: 1, username: user, password: pw}
+broadcast: {destination_ip: 127.0.0.1, self_ip: 127.0.0.1}
+interval: 100ms
+sinks:
+  - type: file
+    path: %s
+`, port, out))
+	if err != nil {
+		t.Fatalf("parseConfig() error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- agent(ctx, cfg) }()
+
+	// counters are held back until a second read agrees, so wait for a sample that has them
+	var sample map[string]any
+	deadline := time.Now().Add(10 * time.Second)
+	for sample == nil && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		sample = sampleWith(t, out, "accumulated_yield_kwh")
+	}
+	cancel()
+
+	if sample == nil {
+		t.Fatalf("no sample with the energy counters was written within 10s")
+	}
+	for _, key := range []string{"timestamp", "model_name", "serial_number", "device_status", "device_status_text", "alarms"} {
+		if _, ok := sample[key]; !ok {
+			t.Errorf("sample is missing %q: %v", key, sample)
+		}
+	}
+	if sample["device_status_text"] != "On-grid" {
+		t.Errorf("device_status_text = %v, want On-grid", sample["device_status_text"])
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("agent() error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("agent didn't stop within 10s of its context being cancelled")
+	}
+}
+
+// sampleWith returns the first sample in the file that has key, or nil
+func sampleWith(t *testing.T, path, key string) map[string]any {
+	t.Helper()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var sample map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			// the last line may be half written
+			continue
+		}
+		if _, ok := sample[key]; ok {
+			return sample
+		}
+	}
+	return nil
+}
+
+func TestAgentSinkSetupError(t *testing.T) {
+	cfg, err := parseTestConfig(t, testConfigBase)
+	if err != nil {
+		t.Fatalf("parseConfig() error: %v", err)
+	}
+
+	// the default mqtt sink, without a broker
+	if err := agent(context.Background(), cfg); err == nil {
+		t.Errorf("agent() should fail to set up the mqtt sink")
+	}
+}
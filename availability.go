@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	payloadOnline  = "online"
+	payloadOffline = "offline"
+)
+
+// availability tracks the agent's own availability (backed by the LWT), and separately whether each inverter is reachable,
+// so subscribers can tell stale data apart from live data
+type availability struct {
+	cfg *LoadedConfig
+	mc  mqtt.Client
+
+	inverterOnline map[*inverter]*atomic.Bool
+}
+
+func newAvailability(cfg *LoadedConfig) *availability {
+	a := &availability{
+		cfg:            cfg,
+		inverterOnline: make(map[*inverter]*atomic.Bool),
+	}
+	for _, inv := range cfg.inverters {
+		a.inverterOnline[inv] = &atomic.Bool{}
+	}
+	return a
+}
+
+func availabilityPayload(online bool) string {
+	if online {
+		return payloadOnline
+	}
+	return payloadOffline
+}
+
+// publishAll is called on every broker (re)connect, as the retained state may have been lost or replaced by the LWT
+func (a *availability) publishAll(c mqtt.Client) {
+	c.Publish(a.cfg.MQTT.AvailabilityTopic, a.cfg.MQTT.QoS, true, payloadOnline)
+	for inv, online := range a.inverterOnline {
+		c.Publish(inv.availabilityTopic, a.cfg.MQTT.QoS, true, availabilityPayload(online.Load()))
+	}
+}
+
+// setInverterOnline publishes the inverter state, only if it changed
+func (a *availability) setInverterOnline(inv *inverter, online bool) {
+	if a.inverterOnline[inv].Swap(online) == online || a.mc == nil {
+		return
+	}
+
+	slog.Info("inverter availability changed", "inverter", inv.name, "online", online)
+	token := a.mc.Publish(inv.availabilityTopic, a.cfg.MQTT.QoS, true, availabilityPayload(online))
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		slog.Warn("mqtt publish inverter availability error", "err", token.Error())
+	}
+}
+
+// publishOffline is for a clean shutdown, where the broker won't send the LWT for us
+func (a *availability) publishOffline() {
+	topics := []string{}
+	for _, inv := range a.cfg.inverters {
+		topics = append(topics, inv.availabilityTopic)
+	}
+	topics = append(topics, a.cfg.MQTT.AvailabilityTopic)
+
+	for _, topic := range topics {
+		token := a.mc.Publish(topic, a.cfg.MQTT.QoS, true, payloadOffline)
+		if !token.WaitTimeout(2*time.Second) || token.Error() != nil {
+			slog.Warn("mqtt publish offline error", "err", token.Error())
+		}
+	}
+}
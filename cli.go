@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
+)
+
+// dialInverter connects and logs in for a one-off CLI command, the same way the agent does, but without retrying
+func dialInverter(ctx context.Context, inv *inverter) (*solar.Client, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	inverter, err := setupInverter(dialCtx, inv)
+	if err != nil {
+		return nil, err
+	}
+
+	err = inverter.BroadcastHello(inv.broadcastDstIP, inv.broadcastSelfIP)
+	if err != nil {
+		slog.Warn("problem when trying to broadcast hello message, proceeding anyway (normal when across VLANs/subnets)", "err", err)
+	}
+
+	go inverter.Run(ctx)
+
+	err = inverter.Login(ctx, inv.modbus.Username, inv.modbus.Password)
+	if err != nil {
+		inverter.Close()
+		return nil, fmt.Errorf("failed to log in: %w", err)
+	}
+	return inverter, nil
+}
+
+// cliOptions are the flags shared by every one-off command that connects to an inverter
+type cliOptions struct {
+	configPath string
+	inverter   string
+	unit       int
+}
+
+func (o *cliOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.configPath, "config", "config.yaml", "Path to YAML config file")
+	fs.StringVar(&o.inverter, "inverter", "", "Name of the inverter to use, if the config has more than one")
+	fs.IntVar(&o.unit, "unit", -1, "Unit ID to talk to, defaults to modbus.slave_id")
+}
+
+// runCLI sets up a connection for a one-off command, exiting non-zero if it fails
+func runCLI(cfg *LoadedConfig, opts cliOptions, fn func(ctx context.Context, inverter *solar.Client) error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if opts.unit > 255 {
+		exitUsage(fmt.Sprintf("invalid unit id %d", opts.unit))
+	}
+	inv, err := cfg.inverterByName(opts.inverter)
+	if err != nil {
+		slog.Error("pick inverter", "err", err)
+		os.Exit(1)
+	}
+
+	inverter, err := dialInverter(ctx, inv)
+	if err != nil {
+		slog.Error("failed to connect to inverter", "err", err)
+		os.Exit(1)
+	}
+	defer inverter.Close()
+
+	if opts.unit >= 0 {
+		inverter = inverter.ForUnit(uint8(opts.unit))
+	}
+
+	err = fn(ctx, inverter)
+	if err != nil {
+		slog.Error("command failed", "err", err)
+		inverter.Close()
+		os.Exit(1)
+	}
+}
+
+// exitUsage is for bad arguments, which are caught before connecting
+func exitUsage(msg string) {
+	fmt.Fprintf(os.Stderr, "%s\n\n", msg)
+	printUsage()
+	os.Exit(2)
+}
+
+func runPower(cfg *LoadedConfig, opts cliOptions, on bool, timeout time.Duration, wait bool) {
+	runCLI(cfg, opts, func(ctx context.Context, inverter *solar.Client) error {
+		switch {
+		case on && wait:
+			return inverter.PowerOnAndConfirm(ctx, timeout)
+		case on:
+			return inverter.PowerOn(ctx)
+		case wait:
+			return inverter.PowerOffAndConfirm(ctx, timeout)
+		default:
+			return inverter.PowerOff(ctx)
+		}
+	})
+}
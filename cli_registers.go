@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
+)
+
+// Most that can be read in one FC 0x03 call
+const maxCLIReadRegisters = 125
+
+func parseAddress(s string) (uint16, error) {
+	addr, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid register address %q", s)
+	}
+	return uint16(addr), nil
+}
+
+// cliSpec builds a spec for ad-hoc reads and writes. For strings, count is the length in registers.
+func cliSpec(addr uint16, typ string, count uint16) (modbus.RegisterSpec, error) {
+	spec := modbus.RegisterSpec{
+		Name:    strconv.Itoa(int(addr)),
+		Address: addr,
+		Type:    typ,
+		Scalar:  1,
+	}
+	if spec.IsString() {
+		spec.StrLen = count * 2
+	}
+	return spec, spec.Validate()
+}
+
+// runRead prints count consecutive values of the same type, starting at addr
+func runRead(cfg *LoadedConfig, opts cliOptions, args []string) {
+	if len(args) < 2 || len(args) > 3 {
+		exitUsage("read needs <addr> <type> [count]")
+	}
+	addr, err := parseAddress(args[0])
+	if err != nil {
+		exitUsage(err.Error())
+	}
+	count := uint64(1)
+	if len(args) == 3 {
+		count, err = strconv.ParseUint(args[2], 10, 16)
+		if err != nil || count == 0 {
+			exitUsage(fmt.Sprintf("invalid count %q", args[2]))
+		}
+	}
+
+	spec, err := cliSpec(addr, args[1], uint16(count))
+	if err != nil {
+		exitUsage(err.Error())
+	}
+	values := uint64(1)
+	if !spec.IsString() {
+		values = count
+	}
+	quantity := uint64(spec.Registers()) * values
+	if quantity > maxCLIReadRegisters {
+		exitUsage(fmt.Sprintf("that's %d registers, at most %d can be read at once", quantity, maxCLIReadRegisters))
+	}
+
+	runCLI(cfg, opts, func(ctx context.Context, inverter *solar.Client) error {
+		b, err := inverter.ReadRegisters(ctx, addr, uint16(quantity))
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ADDR\tTYPE\tVALUE\tRAW")
+		for i := range values {
+			spec.Address = addr + uint16(i)*spec.Registers()
+			raw := b[int(i)*int(spec.Registers())*2:][:spec.Registers()*2]
+
+			v, err := spec.Decode(raw)
+			if err != nil {
+				return err
+			}
+			if s, ok := v.(string); ok {
+				v = strconv.Quote(s)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%v\t%s\n", spec.Address, spec.Type, v, hexRegisters(raw))
+		}
+		return w.Flush()
+	})
+}
+
+// runWrite writes a single value, then reads it back
+func runWrite(cfg *LoadedConfig, opts cliOptions, args []string) {
+	if len(args) != 3 {
+		exitUsage("write needs <addr> <type> <value>")
+	}
+	addr, err := parseAddress(args[0])
+	if err != nil {
+		exitUsage(err.Error())
+	}
+	spec, err := cliSpec(addr, args[1], 0)
+	if err != nil {
+		exitUsage(err.Error())
+	}
+	value, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		exitUsage(fmt.Sprintf("invalid value %q", args[2]))
+	}
+	b, err := spec.Encode(value)
+	if err != nil {
+		exitUsage(err.Error())
+	}
+
+	runCLI(cfg, opts, func(ctx context.Context, inverter *solar.Client) error {
+		err := inverter.WriteRegisters(ctx, addr, b)
+		if err != nil {
+			return err
+		}
+
+		readBack, err := inverter.ReadRegisters(ctx, addr, spec.Registers())
+		if err != nil {
+			return fmt.Errorf("wrote %v, but failed to read it back: %w", value, err)
+		}
+		v, err := spec.Decode(readBack)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("wrote %v to %d, read back %v\n", value, addr, v)
+		return nil
+	})
+}
+
+// runDump prints every register from..to (inclusive) with a few decodings, so unknown registers can be eyeballed.
+// Ranges with unsupported registers in them are read one at a time, so the rest still show up.
+func runDump(cfg *LoadedConfig, opts cliOptions, args []string) {
+	if len(args) != 2 {
+		exitUsage("dump needs <from> <to>")
+	}
+	from, err := parseAddress(args[0])
+	if err != nil {
+		exitUsage(err.Error())
+	}
+	to, err := parseAddress(args[1])
+	if err != nil {
+		exitUsage(err.Error())
+	}
+	if to < from {
+		exitUsage("dump range ends before it starts")
+	}
+
+	runCLI(cfg, opts, func(ctx context.Context, inverter *solar.Client) error {
+		// nil for registers the inverter doesn't have
+		regs := make([][]byte, 0, int(to-from)+1)
+
+		for start := int(from); start <= int(to); start += maxCLIReadRegisters {
+			quantity := min(maxCLIReadRegisters, int(to)-start+1)
+
+			b, err := inverter.ReadRegisters(ctx, uint16(start), uint16(quantity))
+			if modbus.IsException(err, modbus.ExceptionIllegalDataAddress) {
+				for addr := start; addr < start+quantity; addr++ {
+					b, err := inverter.ReadRegisters(ctx, uint16(addr), 1)
+					if modbus.IsException(err, modbus.ExceptionIllegalDataAddress) {
+						regs = append(regs, nil)
+						continue
+					}
+					if err != nil {
+						return fmt.Errorf("failed to read %d: %w", addr, err)
+					}
+					regs = append(regs, b)
+				}
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read %d registers at %d: %w", quantity, start, err)
+			}
+			for i := range quantity {
+				regs = append(regs, b[i*2:i*2+2])
+			}
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(w, "ADDR\tHEX\tU16\tI16\tU32\tI32\tASCII\t")
+		for i, reg := range regs {
+			addr := int(from) + i
+			if reg == nil {
+				fmt.Fprintf(w, "%d\t--\t\t\t\t\t\t\n", addr)
+				continue
+			}
+
+			d := decodeRegisterAt(regs, i)
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t\n", addr, d.Hex, d.U16, d.I16, d.U32, d.I32, d.ASCII)
+		}
+		return w.Flush()
+	})
+}
+
+// registerDecodings are the likely ways to read an unknown register, as strings for printing
+type registerDecodings struct {
+	Hex   string `json:"hex"`
+	U16   string `json:"u16"`
+	I16   string `json:"i16"`
+	U32   string `json:"u32,omitempty"`
+	I32   string `json:"i32,omitempty"`
+	ASCII string `json:"ascii"`
+}
+
+// decodeRegisterAt decodes regs[i], which must not be nil. The 32 bit values use the next register too, so are
+// empty if that one is missing.
+func decodeRegisterAt(regs [][]byte, i int) registerDecodings {
+	u16 := binary.BigEndian.Uint16(regs[i])
+	d := registerDecodings{
+		Hex:   fmt.Sprintf("%04x", u16),
+		U16:   strconv.Itoa(int(u16)),
+		I16:   strconv.Itoa(int(int16(u16))),
+		ASCII: printableASCII(regs[i]),
+	}
+	if i+1 < len(regs) && regs[i+1] != nil {
+		v := uint32(u16)<<16 | uint32(binary.BigEndian.Uint16(regs[i+1]))
+		d.U32, d.I32 = strconv.FormatUint(uint64(v), 10), strconv.Itoa(int(int32(v)))
+	}
+	return d
+}
+
+func runInfo(cfg *LoadedConfig, opts cliOptions) {
+	runCLI(cfg, opts, func(ctx context.Context, inverter *solar.Client) error {
+		infos, err := inverter.QueryDeviceInfos(ctx)
+		if err != nil {
+			return err
+		}
+		return printJSON(infos)
+	})
+}
+
+// runQuery does the same query as the agent, so it uses the device's register map if it has one
+func runQuery(cfg *LoadedConfig, opts cliOptions) {
+	runCLI(cfg, opts, func(ctx context.Context, inverter *solar.Client) error {
+		// runCLI already made sure these are valid
+		inv, err := cfg.inverterByName(opts.inverter)
+		if err != nil {
+			return err
+		}
+		unitID := inv.modbus.slaveID()
+		if opts.unit >= 0 {
+			unitID = uint8(opts.unit)
+		}
+		dev := inv.deviceByUnit(unitID)
+
+		d, err := querySample(ctx, dev, inverter)
+		if err != nil {
+			return err
+		}
+		d.Device = dev.name
+		return printJSON(d)
+	})
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func hexRegisters(b []byte) string {
+	regs := []string{}
+	for i := 0; i+1 < len(b); i += 2 {
+		regs = append(regs, fmt.Sprintf("%04x", binary.BigEndian.Uint16(b[i:])))
+	}
+	return strings.Join(regs, " ")
+}
+
+func printableASCII(b []byte) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return '.'
+		}
+		return r
+	}, string(b))
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
+)
+
+// A command received on <commands.topic>/<name> (or <commands.topic>/<device>/<name>), waiting to be run against the device
+type commandRequest struct {
+	dev     *device
+	name    string
+	payload []byte
+}
+
+type commandResult struct {
+	Command       string    `json:"command"`
+	Device        string    `json:"device,omitempty"`
+	Success       bool      `json:"success"`
+	Error         string    `json:"error,omitempty"`
+	ExceptionCode uint8     `json:"exception_code,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+type commandFunc func(ctx context.Context, cfg *LoadedConfig, inverter *solar.Client, payload []byte) error
+
+// Every command the agent knows about. Only those in commands.allow are actually accepted.
+var commandHandlers = map[string]commandFunc{
+	// these are confirmed by commandConfirms
+	"power_on": func(ctx context.Context, cfg *LoadedConfig, inverter *solar.Client, payload []byte) error {
+		return inverter.PowerOn(ctx)
+	},
+	"power_off": func(ctx context.Context, cfg *LoadedConfig, inverter *solar.Client, payload []byte) error {
+		return inverter.PowerOff(ctx)
+	},
+	"active_power_limit": func(ctx context.Context, cfg *LoadedConfig, inverter *solar.Client, payload []byte) error {
+		percent, err := parseNumberPayload(payload)
+		if err != nil {
+			return err
+		}
+		return inverter.SetActivePowerPercentage(ctx, percent)
+	},
+	"active_power_limit_w": func(ctx context.Context, cfg *LoadedConfig, inverter *solar.Client, payload []byte) error {
+		watts, err := parseNumberPayload(payload)
+		if err != nil {
+			return err
+		}
+		return inverter.SetActivePowerLimit(ctx, watts)
+	},
+	"export_limit": func(ctx context.Context, cfg *LoadedConfig, inverter *solar.Client, payload []byte) error {
+		limit, err := parseExportLimitPayload(payload)
+		if err != nil {
+			return err
+		}
+		mode, err := solar.ParseExportLimitMode(limit.Mode)
+		if err != nil {
+			return err
+		}
+		return inverter.SetExportLimit(ctx, mode, limit.Value)
+	},
+	"battery_working_mode": func(ctx context.Context, cfg *LoadedConfig, inverter *solar.Client, payload []byte) error {
+		name, err := parseStringPayload(payload)
+		if err != nil {
+			return err
+		}
+		mode, err := solar.ParseBatteryWorkingMode(name)
+		if err != nil {
+			return err
+		}
+		return inverter.SetBatteryWorkingMode(ctx, mode)
+	},
+	"battery_grid_charge": func(ctx context.Context, cfg *LoadedConfig, inverter *solar.Client, payload []byte) error {
+		enabled, err := parseBoolPayload(payload)
+		if err != nil {
+			return err
+		}
+		return inverter.SetBatteryGridCharge(ctx, enabled)
+	},
+	"battery_force_charge": func(ctx context.Context, cfg *LoadedConfig, inverter *solar.Client, payload []byte) error {
+		force, err := parseForcePayload(payload)
+		if err != nil {
+			return err
+		}
+		return inverter.ForceBatteryCharge(ctx, force.PowerW, time.Duration(force.Minutes*float64(time.Minute)))
+	},
+	"battery_force_discharge": func(ctx context.Context, cfg *LoadedConfig, inverter *solar.Client, payload []byte) error {
+		force, err := parseForcePayload(payload)
+		if err != nil {
+			return err
+		}
+		return inverter.ForceBatteryDischarge(ctx, force.PowerW, time.Duration(force.Minutes*float64(time.Minute)))
+	},
+	"battery_force_stop": func(ctx context.Context, cfg *LoadedConfig, inverter *solar.Client, payload []byte) error {
+		return inverter.StopForcedBattery(ctx)
+	},
+}
+
+// Commands that only succeed once the device status changes, which can take minutes.
+// They're waited for alongside polling (rather than holding up the poller), and the result published once they're done.
+var commandConfirms = map[string]func(ctx context.Context, cfg *LoadedConfig, inverter *solar.Client) error{
+	"power_on": func(ctx context.Context, cfg *LoadedConfig, inverter *solar.Client) error {
+		return inverter.ConfirmPoweredOn(ctx, cfg.powerTimeout)
+	},
+	"power_off": func(ctx context.Context, cfg *LoadedConfig, inverter *solar.Client) error {
+		return inverter.ConfirmPoweredOff(ctx, cfg.powerTimeout)
+	},
+}
+
+// parseNumberPayload accepts either a plain number, or json like {"value": 50}
+func parseNumberPayload(payload []byte) (float64, error) {
+	payload = bytes.TrimSpace(payload)
+
+	if bytes.HasPrefix(payload, []byte("{")) {
+		var v struct {
+			Value *float64 `json:"value"`
+		}
+		if err := json.Unmarshal(payload, &v); err != nil {
+			return 0, fmt.Errorf("invalid json payload: %v", err)
+		}
+		if v.Value == nil {
+			return 0, fmt.Errorf("json payload is missing \"value\"")
+		}
+		return *v.Value, nil
+	}
+
+	// ParseFloat takes "NaN" and "Inf" too, which would slip through range checks
+	f, err := strconv.ParseFloat(string(payload), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("invalid number payload %q", payload)
+	}
+	return f, nil
+}
+
+// parseStringPayload accepts either plain text, or json like {"value": "time_of_use"}
+func parseStringPayload(payload []byte) (string, error) {
+	payload = bytes.TrimSpace(payload)
+
+	if bytes.HasPrefix(payload, []byte("{")) {
+		var v struct {
+			Value *string `json:"value"`
+		}
+		if err := json.Unmarshal(payload, &v); err != nil {
+			return "", fmt.Errorf("invalid json payload: %v", err)
+		}
+		if v.Value == nil {
+			return "", fmt.Errorf("json payload is missing \"value\"")
+		}
+		return *v.Value, nil
+	}
+
+	if len(payload) == 0 {
+		return "", fmt.Errorf("empty payload")
+	}
+	return string(payload), nil
+}
+
+// parseBoolPayload accepts true/false, on/off or 1/0, either plain or as json like {"value": true}
+func parseBoolPayload(payload []byte) (bool, error) {
+	payload = bytes.TrimSpace(payload)
+
+	if bytes.HasPrefix(payload, []byte("{")) {
+		var v struct {
+			Value *bool `json:"value"`
+		}
+		if err := json.Unmarshal(payload, &v); err != nil {
+			return false, fmt.Errorf("invalid json payload: %v", err)
+		}
+		if v.Value == nil {
+			return false, fmt.Errorf("json payload is missing \"value\"")
+		}
+		return *v.Value, nil
+	}
+
+	switch strings.ToLower(string(payload)) {
+	case "true", "on", "1":
+		return true, nil
+	case "false", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean payload %q", payload)
+}
+
+type exportLimitPayload struct {
+	Mode  string  `json:"mode"`
+	Value float64 `json:"value"`
+}
+
+// parseExportLimitPayload expects json like {"mode": "limited_w", "value": 3000}, or just the mode for modes without a value
+func parseExportLimitPayload(payload []byte) (exportLimitPayload, error) {
+	payload = bytes.TrimSpace(payload)
+
+	var v exportLimitPayload
+	if !bytes.HasPrefix(payload, []byte("{")) {
+		v.Mode = string(payload)
+		return v, nil
+	}
+
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return v, fmt.Errorf("invalid json payload: %v", err)
+	}
+	if v.Mode == "" {
+		return v, fmt.Errorf("json payload is missing \"mode\"")
+	}
+	return v, nil
+}
+
+type forcePayload struct {
+	PowerW  float64 `json:"power_w"`
+	Minutes float64 `json:"minutes"`
+}
+
+// parseForcePayload expects json like {"power_w": 2500, "minutes": 60}
+func parseForcePayload(payload []byte) (forcePayload, error) {
+	var v forcePayload
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return v, fmt.Errorf("invalid json payload: %v", err)
+	}
+	if v.PowerW == 0 || v.Minutes == 0 {
+		return v, fmt.Errorf("json payload needs both \"power_w\" and \"minutes\"")
+	}
+	return v, nil
+}
+
+// subscribeCommands forwards command messages to the channel for the device's inverter, to be run by the poller which owns the connection
+func subscribeCommands(c mqtt.Client, cfg *LoadedConfig, cmdChs map[*inverter]chan commandRequest) {
+	if len(cfg.Commands.Allow) == 0 {
+		return
+	}
+
+	for _, dev := range cfg.devices {
+		token := c.Subscribe(dev.commandsTopic+"/+", cfg.MQTT.QoS, func(c mqtt.Client, m mqtt.Message) {
+			name := strings.TrimPrefix(m.Topic(), dev.commandsTopic+"/")
+			req := commandRequest{dev: dev, name: name, payload: m.Payload()}
+
+			if m.Retained() {
+				// a retained command would run again every time we reconnect to the broker
+				slog.Warn("ignoring retained command", "command", name, "device", dev.name, "payload", string(m.Payload()))
+				go publishCommandResult(c, cfg, dev, name, errors.New("retained commands are ignored, publish without the retain flag"))
+				return
+			}
+
+			slog.Info("received command", "command", name, "device", dev.name, "payload", string(m.Payload()))
+
+			select {
+			case cmdChs[dev.inverter] <- req:
+			default:
+				go publishCommandResult(c, cfg, dev, name, errors.New("too many commands pending"))
+			}
+		})
+
+		// don't wait on the token, this is called from the on connect handler
+		go func() {
+			if !token.WaitTimeout(10*time.Second) || token.Error() != nil {
+				slog.Warn("failed to subscribe to command topic", "topic", dev.commandsTopic, "err", token.Error())
+			}
+		}()
+	}
+}
+
+func runCommand(ctx context.Context, cfg *LoadedConfig, inverter *solar.Client, req commandRequest) error {
+	handler, ok := commandHandlers[req.name]
+	if !ok {
+		return fmt.Errorf("unknown command %q", req.name)
+	}
+	if !slices.Contains(cfg.Commands.Allow, req.name) {
+		return fmt.Errorf("command %q is not allowed", req.name)
+	}
+
+	return handler(ctx, cfg, inverter, req.payload)
+}
+
+func publishCommandResult(c mqtt.Client, cfg *LoadedConfig, dev *device, name string, err error) {
+	result := commandResult{
+		Command:   name,
+		Device:    dev.name,
+		Success:   err == nil,
+		Timestamp: time.Now().UTC(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+
+		var exc *modbus.ModbusException
+		if errors.As(err, &exc) {
+			result.ExceptionCode = uint8(exc.ExceptionCode)
+		}
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		slog.Warn("marshal error when sending command result", "err", err)
+		return
+	}
+
+	token := c.Publish(dev.resultTopic+"/"+name, cfg.MQTT.QoS, false, payload)
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		slog.Warn("mqtt publish command result error", "err", token.Error())
+	}
+}
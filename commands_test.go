@@ -0,0 +1,150 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseNumberPayload(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    float64
+		wantErr bool
+	}{
+		{payload: "50", want: 50},
+		{payload: " -1.5\n", want: -1.5},
+		{payload: `{"value": 75}`, want: 75},
+		{payload: `{"value": 0}`, want: 0},
+		{payload: `{}`, wantErr: true},
+		{payload: `{"value": "50"}`, wantErr: true},
+		{payload: "", wantErr: true},
+		{payload: "fifty", wantErr: true},
+		{payload: "NaN", wantErr: true},
+		{payload: "-Inf", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			got, err := parseNumberPayload([]byte(tt.payload))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNumberPayload(%q) error = %v, want error %v", tt.payload, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseNumberPayload(%q) = %v, want %v", tt.payload, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStringPayload(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    string
+		wantErr bool
+	}{
+		{payload: "time_of_use", want: "time_of_use"},
+		{payload: " maximise_self_consumption\n", want: "maximise_self_consumption"},
+		{payload: `{"value": "fully_fed_to_grid"}`, want: "fully_fed_to_grid"},
+		{payload: `{"mode": "time_of_use"}`, wantErr: true},
+		{payload: `{"value": 1}`, wantErr: true},
+		{payload: " ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			got, err := parseStringPayload([]byte(tt.payload))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStringPayload(%q) error = %v, want error %v", tt.payload, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseStringPayload(%q) = %q, want %q", tt.payload, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBoolPayload(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    bool
+		wantErr bool
+	}{
+		{payload: "true", want: true},
+		{payload: "ON", want: true},
+		{payload: "1", want: true},
+		{payload: "false"},
+		{payload: "off\n"},
+		{payload: "0"},
+		{payload: `{"value": true}`, want: true},
+		{payload: `{"value": false}`},
+		{payload: `{"value": "true"}`, wantErr: true},
+		{payload: `{}`, wantErr: true},
+		{payload: "yes", wantErr: true},
+		{payload: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			got, err := parseBoolPayload([]byte(tt.payload))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBoolPayload(%q) error = %v, want error %v", tt.payload, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseBoolPayload(%q) = %v, want %v", tt.payload, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseForcePayload(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    forcePayload
+		wantErr bool
+	}{
+		{payload: `{"power_w": 2500, "minutes": 60}`, want: forcePayload{PowerW: 2500, Minutes: 60}},
+		{payload: `{"power_w": 2500, "minutes": 0.5}`, want: forcePayload{PowerW: 2500, Minutes: 0.5}},
+		{payload: `{"power_w": 2500}`, wantErr: true},
+		{payload: `{"minutes": 60}`, wantErr: true},
+		{payload: "2500", wantErr: true},
+		{payload: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			got, err := parseForcePayload([]byte(tt.payload))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseForcePayload(%q) error = %v, want error %v", tt.payload, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseForcePayload(%q) = %+v, want %+v", tt.payload, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExportLimitPayload(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    exportLimitPayload
+		wantErr bool
+	}{
+		{payload: "unlimited", want: exportLimitPayload{Mode: "unlimited"}},
+		{payload: " zero_export\n", want: exportLimitPayload{Mode: "zero_export"}},
+		{payload: `{"mode": "limited_w", "value": 3000}`, want: exportLimitPayload{Mode: "limited_w", Value: 3000}},
+		{payload: `{"mode": "unlimited"}`, want: exportLimitPayload{Mode: "unlimited"}},
+		{payload: `{"value": 3000}`, wantErr: true},
+		{payload: `{"mode": "limited_w", "value": "3000"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			got, err := parseExportLimitPayload([]byte(tt.payload))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExportLimitPayload(%q) error = %v, want error %v", tt.payload, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseExportLimitPayload(%q) = %+v, want %+v", tt.payload, got, tt.want)
+			}
+		})
+	}
+}
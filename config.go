@@ -2,21 +2,41 @@ package main
 
 import (
 	"fmt"
-	"net"
 	"os"
 	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+type ModbusConfig struct {
+	IP       string  `yaml:"ip"`
+	Port     uint16  `yaml:"port"`
+	SlaveID  *uint8  `yaml:"slave_id"`
+	Username string  `yaml:"username"`
+	Password string  `yaml:"password"`
+	ReadGap  *uint16 `yaml:"read_gap"`
+	// Only warn when the inverter can't prove it knows the password, for firmware that answers the login differently
+	SkipInverterVerify *bool `yaml:"skip_inverter_verify"`
+
+	RegisterMap string `yaml:"register_map"`
+}
+
+// slaveID is the configured unit ID, or 0 if it wasn't set.
+// It's a pointer in the config so an inverter can set 0 rather than falling back to the top level.
+func (mc ModbusConfig) slaveID() uint8 {
+	if mc.SlaveID == nil {
+		return 0
+	}
+	return *mc.SlaveID
+}
+
+type BroadcastConfig struct {
+	DestinationIP string `yaml:"destination_ip"`
+	SelfIP        string `yaml:"self_ip"`
+}
+
 type Config struct {
-	Modbus struct {
-		IP       string `yaml:"ip"`
-		Port     uint16 `yaml:"port"`
-		SlaveID  uint8  `yaml:"slave_id"`
-		Username string `yaml:"username"`
-		Password string `yaml:"password"`
-	} `yaml:"modbus"`
+	Modbus ModbusConfig `yaml:"modbus"`
 
 	MQTT struct {
 		Broker   string `yaml:"broker"`
@@ -26,24 +46,75 @@ type Config struct {
 		Password string `yaml:"password"`
 		QoS      byte   `yaml:"qos"`
 		Retain   bool   `yaml:"retain"`
+
+		AvailabilityTopic         string `yaml:"availability_topic"`
+		InverterAvailabilityTopic string `yaml:"inverter_availability_topic"`
+		AlarmsTopic               string `yaml:"alarms_topic"`
+		InfoTopic                 string `yaml:"info_topic"`
 	} `yaml:"mqtt"`
 
-	Broadcast struct {
-		DestinationIP string `yaml:"destination_ip"`
-		SelfIP        string `yaml:"self_ip"`
-	} `yaml:"broadcast"`
+	Commands struct {
+		Topic       string   `yaml:"topic"`
+		ResultTopic string   `yaml:"result_topic"`
+		Allow       []string `yaml:"allow"`
+		// How long power_on/power_off wait for the device status to change
+		PowerTimeout string `yaml:"power_timeout"`
+	} `yaml:"commands"`
+
+	Metrics struct {
+		Listen string `yaml:"listen"`
+	} `yaml:"metrics"`
+
+	HomeAssistant struct {
+		Enabled         bool   `yaml:"enabled"`
+		DiscoveryPrefix string `yaml:"discovery_prefix"`
+	} `yaml:"homeassistant"`
+
+	Broadcast BroadcastConfig `yaml:"broadcast"`
+
+	Devices   []DeviceConfig   `yaml:"devices"`
+	Inverters []InverterConfig `yaml:"inverters"`
+
+	Sinks []SinkConfig `yaml:"sinks"`
 
 	Interval string `yaml:"interval"`
 	LogQuery bool   `yaml:"log_query"`
 }
 
+type SinkConfig struct {
+	Type string `yaml:"type"`
+	// How many samples to queue before dropping them, if the sink is slow
+	Buffer int `yaml:"buffer"`
+
+	// file
+	Path string `yaml:"path"`
+
+	// webhook
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Timeout string            `yaml:"timeout"`
+
+	// influxdb, also uses url, headers and timeout
+	Token       string            `yaml:"token"`
+	Measurement string            `yaml:"measurement"`
+	Tags        map[string]string `yaml:"tags"`
+	SerialTag   string            `yaml:"serial_tag"`
+	ModelTag    string            `yaml:"model_tag"`
+	BatchSize   int               `yaml:"batch_size"`
+	MaxPending  int               `yaml:"max_pending"`
+
+	timeout time.Duration
+}
+
 type LoadedConfig struct {
 	Config
 
-	interval time.Duration
+	interval     time.Duration
+	powerTimeout time.Duration
 
-	broadcastDstIP  net.IP
-	broadcastSelfIP net.IP
+	inverters []*inverter
+	// every device of every inverter, names are unique across all of them
+	devices []*device
 }
 
 func loadConfig(path string) (*LoadedConfig, error) {
@@ -68,9 +139,47 @@ func parseConfig(cfg *LoadedConfig) error {
 	if cfg.MQTT.ClientID == "" {
 		cfg.MQTT.ClientID = "huawei-solar-go-agent"
 	}
+	// every other topic is under this one, so it can't be empty
+	if cfg.MQTT.Topic == "" {
+		cfg.MQTT.Topic = "solar/inverter"
+	}
 
-	if cfg.Broadcast.DestinationIP == "" {
-		cfg.Broadcast.DestinationIP = "255.255.255.255"
+	if cfg.MQTT.AvailabilityTopic == "" {
+		cfg.MQTT.AvailabilityTopic = cfg.MQTT.Topic + "/availability"
+	}
+	if cfg.MQTT.InverterAvailabilityTopic == "" {
+		cfg.MQTT.InverterAvailabilityTopic = cfg.MQTT.Topic + "/inverter/availability"
+	}
+	if cfg.MQTT.AlarmsTopic == "" {
+		cfg.MQTT.AlarmsTopic = cfg.MQTT.Topic + "/alarms"
+	}
+	if cfg.MQTT.InfoTopic == "" {
+		cfg.MQTT.InfoTopic = cfg.MQTT.Topic + "/info"
+	}
+
+	if cfg.Commands.Topic == "" {
+		cfg.Commands.Topic = cfg.MQTT.Topic + "/set"
+	}
+	if cfg.Commands.ResultTopic == "" {
+		cfg.Commands.ResultTopic = cfg.MQTT.Topic + "/result"
+	}
+	for _, name := range cfg.Commands.Allow {
+		if _, ok := commandHandlers[name]; !ok {
+			return fmt.Errorf("unknown command %q in commands.allow", name)
+		}
+	}
+
+	cfg.powerTimeout = 3 * time.Minute
+	if cfg.Commands.PowerTimeout != "" {
+		d, err := time.ParseDuration(cfg.Commands.PowerTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid commands.power_timeout %q: %v", cfg.Commands.PowerTimeout, err)
+		}
+		cfg.powerTimeout = d
+	}
+
+	if cfg.HomeAssistant.DiscoveryPrefix == "" {
+		cfg.HomeAssistant.DiscoveryPrefix = "homeassistant"
 	}
 
 	interval := 30 * time.Second
@@ -83,10 +192,73 @@ func parseConfig(cfg *LoadedConfig) error {
 	}
 	cfg.interval = interval
 
-	cfg.broadcastDstIP = net.ParseIP(cfg.Broadcast.DestinationIP)
-	cfg.broadcastSelfIP = net.ParseIP(cfg.Broadcast.SelfIP)
-	if cfg.broadcastDstIP == nil || cfg.broadcastSelfIP == nil {
-		return fmt.Errorf("invalid broadcast ip %q; %q", cfg.Broadcast.DestinationIP, cfg.Broadcast.SelfIP)
+	if len(cfg.Sinks) == 0 {
+		cfg.Sinks = []SinkConfig{{Type: "mqtt"}}
+	}
+	for i := range cfg.Sinks {
+		err := parseSinkConfig(cfg, &cfg.Sinks[i])
+		if err != nil {
+			return fmt.Errorf("sink %d (%s): %v", i, cfg.Sinks[i].Type, err)
+		}
+	}
+
+	return parseInverters(cfg)
+}
+
+func parseSinkConfig(cfg *LoadedConfig, sc *SinkConfig) error {
+	if sc.Buffer < 0 {
+		return fmt.Errorf("buffer can't be negative")
+	}
+	if sc.Buffer == 0 {
+		sc.Buffer = 10
+	}
+
+	sc.timeout = 10 * time.Second
+	if sc.Timeout != "" {
+		d, err := time.ParseDuration(sc.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout %q: %v", sc.Timeout, err)
+		}
+		sc.timeout = d
+	}
+
+	switch sc.Type {
+	// mqtt.broker is checked when the agent starts, so the one-off commands work without it
+	case "mqtt":
+	case "stdout":
+	case "file":
+		if sc.Path == "" {
+			return fmt.Errorf("path must be set")
+		}
+	case "webhook":
+		if sc.URL == "" {
+			return fmt.Errorf("url must be set")
+		}
+	case "influxdb":
+		if sc.URL == "" {
+			return fmt.Errorf("url must be set")
+		}
+		if sc.Measurement == "" {
+			sc.Measurement = "solar"
+		}
+		if sc.SerialTag == "" {
+			sc.SerialTag = "serial"
+		}
+		if sc.ModelTag == "" {
+			sc.ModelTag = "model"
+		}
+		if sc.BatchSize < 0 || sc.MaxPending < 0 {
+			return fmt.Errorf("batch_size and max_pending can't be negative")
+		}
+		if sc.BatchSize == 0 {
+			sc.BatchSize = 1
+		}
+		if sc.MaxPending == 0 {
+			// about a day at the default interval
+			sc.MaxPending = 3000
+		}
+	default:
+		return fmt.Errorf("unknown sink type")
 	}
 
 	return nil
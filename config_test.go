@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// the least a config needs to load
+const testConfigBase = "modbus: {ip: 127.0.0.1}\nbroadcast: {self_ip: 127.0.0.1}\n"
+
+// parseTestConfig is loadConfig without the file
+func parseTestConfig(t *testing.T, contents string) (*LoadedConfig, error) {
+	t.Helper()
+
+	var cfg LoadedConfig
+	if err := yaml.Unmarshal([]byte(contents), &cfg.Config); err != nil {
+		t.Fatalf("unmarshal config: %v", err)
+	}
+	return &cfg, parseConfig(&cfg)
+}
+
+func TestParseConfigTopics(t *testing.T) {
+	tests := []struct {
+		name      string
+		yaml      string
+		wantTopic string
+		wantAvail string
+	}{
+		{name: "default", yaml: testConfigBase, wantTopic: "solar/inverter", wantAvail: "solar/inverter/availability"},
+		{name: "set", yaml: testConfigBase + "mqtt: {topic: home/pv}\n", wantTopic: "home/pv", wantAvail: "home/pv/availability"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseTestConfig(t, tt.yaml)
+			if err != nil {
+				t.Fatalf("parseConfig() error: %v", err)
+			}
+			if cfg.MQTT.Topic != tt.wantTopic || cfg.MQTT.AvailabilityTopic != tt.wantAvail {
+				t.Errorf("topics = %q, %q, want %q, %q", cfg.MQTT.Topic, cfg.MQTT.AvailabilityTopic, tt.wantTopic, tt.wantAvail)
+			}
+			if topic := cfg.devices[0].topic; topic != tt.wantTopic {
+				t.Errorf("device topic = %q, want %q", topic, tt.wantTopic)
+			}
+		})
+	}
+}
+
+func TestMQTTSinkWithoutBroker(t *testing.T) {
+	// the one-off commands load the config too, and don't need a broker
+	cfg, err := parseTestConfig(t, testConfigBase)
+	if err != nil {
+		t.Fatalf("parseConfig() error: %v", err)
+	}
+
+	var gen atomic.Uint64
+	_, err = setupSinks(cfg, nil, &gen, newAgentMetrics())
+	if err == nil {
+		t.Errorf("setupSinks() with an mqtt sink and no broker should fail")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
+)
+
+// DeviceConfig is one unit ID behind the connection, i.e. one of several cascaded inverters, or a power meter
+type DeviceConfig struct {
+	Name string `yaml:"name"`
+	// Required, as 0 is a valid unit ID (often the meter)
+	UnitID *uint8 `yaml:"unit_id"`
+	// defaults to <mqtt.topic>/<name>, or <inverter topic>/<name>
+	Topic string `yaml:"topic"`
+	// defaults to the inverter's modbus.register_map, or the built-in registers
+	RegisterMap string `yaml:"register_map"`
+}
+
+// device is a DeviceConfig with every topic resolved. Without any devices for an inverter,
+// there's a single one named after the inverter, using its modbus and mqtt settings.
+type device struct {
+	name     string
+	unitID   uint8
+	inverter *inverter
+
+	topic         string
+	alarmsTopic   string
+	infoTopic     string
+	commandsTopic string
+	resultTopic   string
+
+	registerMap solar.RegisterMap
+}
+
+func parseDevices(inv *inverter, devices []DeviceConfig) error {
+	if len(devices) == 0 {
+		inv.devices = []*device{{
+			name:          inv.name,
+			unitID:        inv.modbus.slaveID(),
+			inverter:      inv,
+			topic:         inv.topic,
+			alarmsTopic:   inv.alarmsTopic,
+			infoTopic:     inv.infoTopic,
+			commandsTopic: inv.commandsTopic,
+			resultTopic:   inv.resultTopic,
+			registerMap:   inv.registerMap,
+		}}
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for i, dc := range devices {
+		if dc.Name == "" || strings.ContainsAny(dc.Name, "/+#") {
+			return fmt.Errorf("device %d: name must be set, and can't contain '/', '+' or '#'", i)
+		}
+		if seen[dc.Name] {
+			return fmt.Errorf("device %d: duplicate name %q", i, dc.Name)
+		}
+		seen[dc.Name] = true
+
+		if dc.UnitID == nil {
+			return fmt.Errorf("device %q: unit_id must be set", dc.Name)
+		}
+
+		dev := &device{
+			name:          dc.Name,
+			unitID:        *dc.UnitID,
+			inverter:      inv,
+			topic:         dc.Topic,
+			commandsTopic: inv.commandsTopic + "/" + dc.Name,
+			resultTopic:   inv.resultTopic + "/" + dc.Name,
+			registerMap:   inv.registerMap,
+		}
+		if dev.topic == "" {
+			dev.topic = inv.topic + "/" + dc.Name
+		}
+		dev.alarmsTopic = dev.topic + "/alarms"
+		dev.infoTopic = dev.topic + "/info"
+
+		if dc.RegisterMap != "" {
+			m, err := solar.LoadRegisterMap(dc.RegisterMap)
+			if err != nil {
+				return fmt.Errorf("device %q: invalid register map %q: %v", dc.Name, dc.RegisterMap, err)
+			}
+			dev.registerMap = m
+		}
+
+		inv.devices = append(inv.devices, dev)
+	}
+
+	return nil
+}
+
+// deviceByName finds the device a sample came from
+func (cfg *LoadedConfig) deviceByName(name string) *device {
+	for _, dev := range cfg.devices {
+		if dev.name == name {
+			return dev
+		}
+	}
+	return nil
+}
+
+// deviceByUnit finds the device a CLI command's unit ID belongs to. Unit IDs that aren't one of the devices
+// get the inverter's register map, the same as a device without its own.
+func (inv *inverter) deviceByUnit(unitID uint8) *device {
+	for _, dev := range inv.devices {
+		if dev.unitID == unitID {
+			return dev
+		}
+	}
+	return &device{unitID: unitID, inverter: inv, registerMap: inv.registerMap}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
+)
+
+// publishDeviceInfos publishes the descriptions for each of the inverter's devices, retained to their info topics.
+// It's best effort, older firmware doesn't always answer device info requests.
+func publishDeviceInfos(ctx context.Context, mc mqtt.Client, cfg *LoadedConfig, inv *inverter, inverter *solar.Client) {
+	if mc == nil {
+		return
+	}
+
+	for _, dev := range inv.devices {
+		infos, err := inverter.ForUnit(dev.unitID).QueryDeviceInfos(ctx)
+		if err != nil {
+			slog.Warn("failed to query device info", "device", dev.name, "err", err)
+			continue
+		}
+		for _, info := range infos {
+			slog.Info("device info", "device", dev.name, "model", info.Model, "esn", info.ESN, "software_version", info.SoftwareVersion, "device_id", info.DeviceID)
+		}
+
+		payload, err := json.Marshal(infos)
+		if err != nil {
+			slog.Warn("marshal error when sending device info", "err", err)
+			continue
+		}
+
+		token := mc.Publish(dev.infoTopic, cfg.MQTT.QoS, true, payload)
+		if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+			slog.Warn("mqtt publish device info error", "err", token.Error())
+		}
+	}
+}
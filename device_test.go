@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseDevices(t *testing.T) {
+	slaveID := uint8(1)
+	unit := func(id uint8) *uint8 { return &id }
+	newInverter := func() *inverter {
+		return &inverter{
+			name:          "home",
+			modbus:        ModbusConfig{SlaveID: &slaveID},
+			topic:         "solar/home",
+			alarmsTopic:   "solar/home/alarms",
+			infoTopic:     "solar/home/info",
+			commandsTopic: "solar/set/home",
+			resultTopic:   "solar/result/home",
+		}
+	}
+
+	t.Run("none", func(t *testing.T) {
+		inv := newInverter()
+		if err := parseDevices(inv, nil); err != nil {
+			t.Fatalf("parseDevices() error: %v", err)
+		}
+		if len(inv.devices) != 1 {
+			t.Fatalf("got %d devices, want the inverter itself", len(inv.devices))
+		}
+		dev := inv.devices[0]
+		if dev.name != "home" || dev.unitID != 1 || dev.topic != "solar/home" || dev.alarmsTopic != "solar/home/alarms" || dev.commandsTopic != "solar/set/home" {
+			t.Errorf("device = %+v", dev)
+		}
+	})
+
+	t.Run("devices", func(t *testing.T) {
+		inv := newInverter()
+		err := parseDevices(inv, []DeviceConfig{
+			{Name: "inverter", UnitID: unit(1)},
+			{Name: "meter", UnitID: unit(0), Topic: "power/meter"},
+		})
+		if err != nil {
+			t.Fatalf("parseDevices() error: %v", err)
+		}
+		if len(inv.devices) != 2 {
+			t.Fatalf("got %d devices, want 2", len(inv.devices))
+		}
+
+		dev := inv.devices[0]
+		if dev.unitID != 1 || dev.topic != "solar/home/inverter" || dev.infoTopic != "solar/home/inverter/info" || dev.resultTopic != "solar/result/home/inverter" {
+			t.Errorf("inverter device = %+v", dev)
+		}
+		meter := inv.devices[1]
+		if meter.unitID != 0 || meter.topic != "power/meter" || meter.alarmsTopic != "power/meter/alarms" || meter.commandsTopic != "solar/set/home/meter" {
+			t.Errorf("meter device = %+v", meter)
+		}
+	})
+
+	invalid := []struct {
+		name    string
+		devices []DeviceConfig
+	}{
+		{name: "no name", devices: []DeviceConfig{{UnitID: unit(1)}}},
+		{name: "wildcard in name", devices: []DeviceConfig{{Name: "meter/+", UnitID: unit(1)}}},
+		{name: "duplicate name", devices: []DeviceConfig{{Name: "a", UnitID: unit(1)}, {Name: "a", UnitID: unit(2)}}},
+		{name: "no unit id", devices: []DeviceConfig{{Name: "meter"}}},
+		{name: "missing register map", devices: []DeviceConfig{{Name: "meter", UnitID: unit(0), RegisterMap: "/nonexistent/map.yaml"}}},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := parseDevices(newInverter(), tt.devices); err == nil {
+				t.Errorf("parseDevices() should fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
+)
+
+type discoverOptions struct {
+	interfaces []string
+	dest       string
+	self       string
+	helloPort  int
+	wait       time.Duration
+	ports      []int
+	unit       int
+	json       bool
+}
+
+type discoveredInverter struct {
+	solar.HelloReply
+	Ports []solar.PortProbe `json:"ports"`
+}
+
+func runDiscover(opts discoverOptions) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	targets, err := discoveryTargets(opts)
+	if err != nil {
+		slog.Error("discovery targets", "err", err)
+		os.Exit(1)
+	}
+	for _, t := range targets {
+		slog.Info("broadcasting hello", "dst", t.Dst, "self", t.Self)
+	}
+
+	replies, err := solar.Discover(ctx, targets, opts.wait)
+	if err != nil {
+		slog.Error("discovery failed", "err", err)
+		os.Exit(1)
+	}
+	slog.Info("discovery finished", "replies", len(replies))
+
+	found := make([]discoveredInverter, len(replies))
+	var wg sync.WaitGroup
+	for i, reply := range replies {
+		found[i] = discoveredInverter{HelloReply: reply, Ports: make([]solar.PortProbe, len(opts.ports))}
+		for j, port := range opts.ports {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				found[i].Ports[j] = solar.ProbeModbusPort(ctx, reply.IP, port, uint8(opts.unit), 5*time.Second)
+			}()
+		}
+	}
+	wg.Wait()
+
+	slices.SortFunc(found, func(a, b discoveredInverter) int {
+		return slices.Compare(a.IP.To16(), b.IP.To16())
+	})
+
+	if opts.json {
+		err = printJSON(found)
+	} else {
+		err = printDiscovered(found)
+	}
+	if err != nil {
+		slog.Error("print results", "err", err)
+		os.Exit(1)
+	}
+}
+
+// discoveryTargets is the -dest/-self pair if set, plus the broadcast address of every chosen interface.
+// Without either, every interface that's up and can broadcast is used.
+func discoveryTargets(opts discoverOptions) ([]solar.DiscoveryTarget, error) {
+	targets := []solar.DiscoveryTarget{}
+
+	if opts.dest != "" {
+		dst, self := net.ParseIP(opts.dest), net.ParseIP(opts.self)
+		if dst == nil || self == nil {
+			return nil, fmt.Errorf("-dest and -self must both be IPs, got %q and %q", opts.dest, opts.self)
+		}
+		targets = append(targets, solar.DiscoveryTarget{Dst: dst, Self: self, Port: opts.helloPort})
+		if len(opts.interfaces) == 0 {
+			return targets, nil
+		}
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %v", err)
+	}
+
+	for _, name := range opts.interfaces {
+		if !slices.ContainsFunc(ifaces, func(iface net.Interface) bool { return iface.Name == name }) {
+			return nil, fmt.Errorf("no interface named %q", name)
+		}
+	}
+
+	for _, iface := range ifaces {
+		if len(opts.interfaces) > 0 {
+			if !slices.Contains(opts.interfaces, iface.Name) {
+				continue
+			}
+		} else if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagBroadcast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list addresses of %s: %v", iface.Name, err)
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil {
+				continue
+			}
+			targets = append(targets, solar.DiscoveryTarget{Dst: broadcastAddr(ipNet), Self: ipNet.IP.To4(), Port: opts.helloPort})
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no interfaces with an ipv4 address to broadcast on")
+	}
+	return targets, nil
+}
+
+func broadcastAddr(ipNet *net.IPNet) net.IP {
+	ip := binary.BigEndian.Uint32(ipNet.IP.To4())
+	mask := binary.BigEndian.Uint32(net.IP(ipNet.Mask).To4())
+	return binary.BigEndian.AppendUint32(nil, ip|^mask)
+}
+
+func printDiscovered(found []discoveredInverter) error {
+	if len(found) == 0 {
+		fmt.Println("no inverters answered")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "IP\tMODEL\tESN\tPORTS")
+	for _, inv := range found {
+		model, esn := "?", "?"
+		if inv.Info != nil {
+			model, esn = inv.Info.Model, inv.Info.ESN
+		}
+
+		ports := []string{}
+		for _, p := range inv.Ports {
+			switch {
+			case p.Modbus && p.Model != "":
+				ports = append(ports, fmt.Sprintf("%d (modbus, %s)", p.Port, p.Model))
+			case p.Modbus:
+				ports = append(ports, fmt.Sprintf("%d (modbus)", p.Port))
+			case p.Open:
+				ports = append(ports, fmt.Sprintf("%d (open, no modbus answer)", p.Port))
+			}
+		}
+		if len(ports) == 0 {
+			ports = append(ports, "none answered")
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", inv.IP, model, esn, strings.Join(ports, ", "))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
+)
+
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model,omitempty"`
+	SerialNumber string   `json:"serial_number,omitempty"`
+}
+
+type haAvailability struct {
+	Topic string `json:"topic"`
+}
+
+type haSensorConfig struct {
+	Name              string   `json:"name"`
+	UniqueID          string   `json:"unique_id"`
+	StateTopic        string   `json:"state_topic"`
+	ValueTemplate     string   `json:"value_template"`
+	DeviceClass       string   `json:"device_class,omitempty"`
+	UnitOfMeasurement string   `json:"unit_of_measurement,omitempty"`
+	StateClass        string   `json:"state_class,omitempty"`
+	EntityCategory    string   `json:"entity_category,omitempty"`
+	Device            haDevice `json:"device"`
+
+	Availability     []haAvailability `json:"availability"`
+	AvailabilityMode string           `json:"availability_mode"`
+}
+
+// Field metadata is derived from the json key's unit suffix, which solar.Data keeps consistent (and register maps should too)
+type haSensorClass struct {
+	suffix      string
+	deviceClass string
+	unit        string
+	stateClass  string
+}
+
+var haSensorClasses = []haSensorClass{
+	{"_kwh", "energy", "kWh", "total_increasing"},
+	{"_w", "power", "W", "measurement"},
+	{"_v", "voltage", "V", "measurement"},
+	{"_a", "current", "A", "measurement"},
+	{"_hz", "frequency", "Hz", "measurement"},
+	{"_c", "temperature", "°C", "measurement"},
+}
+
+func haClassFor(key string) haSensorClass {
+	// reactive power is stored in "_w" fields, but HA wants var
+	if strings.Contains(key, "reactive_power") {
+		return haSensorClass{suffix: "_w", deviceClass: "reactive_power", unit: "var", stateClass: "measurement"}
+	}
+	if strings.HasSuffix(key, "soc_percent") {
+		return haSensorClass{suffix: "_percent", deviceClass: "battery", unit: "%", stateClass: "measurement"}
+	}
+
+	for _, c := range haSensorClasses {
+		if strings.HasSuffix(key, c.suffix) {
+			return c
+		}
+	}
+	return haSensorClass{}
+}
+
+// i.e. "pv1_voltage_v" -> "PV1 voltage", "meter_grid_a_voltage_v" -> "Meter grid A voltage"
+func haFriendlyName(key string, class haSensorClass) string {
+	words := strings.Split(strings.TrimSuffix(key, class.suffix), "_")
+	for i, w := range words {
+		switch {
+		case w == "cum":
+			words[i] = "cumulative"
+		case len(w) == 1 || w == "soc" || strings.HasPrefix(w, "pv") || strings.HasPrefix(w, "mppt"):
+			words[i] = strings.ToUpper(w)
+		}
+	}
+	name := strings.Join(words, " ")
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func haNodeID(serial string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, serial)
+}
+
+// haSensorConfigs builds a discovery config message per sample field, keyed by config topic
+func haSensorConfigs(cfg *LoadedConfig, dev *device, d *solar.Sample) map[string]haSensorConfig {
+	// devices without a serial (i.e. a meter with its own register map) fall back to their name,
+	// or their state topic if they're the only, unnamed device, which is unique to this device either way
+	id, name := d.SerialNumber(), "Huawei "+d.ModelName()
+	if id == "" && dev.name != "" {
+		id = dev.name
+	}
+	if id == "" {
+		id = dev.topic
+	}
+	if d.ModelName() == "" {
+		name = "Huawei"
+		if dev.name != "" {
+			name = dev.name
+		}
+	}
+
+	nodeID := haNodeID(id)
+	device := haDevice{
+		Identifiers:  []string{"huawei_solar_" + nodeID},
+		Name:         name,
+		Manufacturer: "Huawei",
+		Model:        d.ModelName(),
+		SerialNumber: d.SerialNumber(),
+	}
+
+	configs := make(map[string]haSensorConfig)
+	for _, field := range d.Fields {
+		key := field.Key
+
+		// lists (i.e. alarms) have their own topic
+		if _, ok := field.Number(); !ok && !field.IsString() {
+			continue
+		}
+
+		class := haClassFor(key)
+		sensor := haSensorConfig{
+			Name:              haFriendlyName(key, class),
+			UniqueID:          fmt.Sprintf("huawei_solar_%s_%s", nodeID, key),
+			StateTopic:        dev.topic,
+			ValueTemplate:     fmt.Sprintf("{{ value_json.%s }}", key),
+			DeviceClass:       class.deviceClass,
+			UnitOfMeasurement: class.unit,
+			StateClass:        class.stateClass,
+			Device:            device,
+
+			// greyed out if either the agent or the inverter is gone
+			Availability: []haAvailability{
+				{Topic: cfg.MQTT.AvailabilityTopic},
+				{Topic: dev.inverter.availabilityTopic},
+			},
+			AvailabilityMode: "all",
+		}
+
+		// Things that don't change, or aren't telemetry
+		if field.IsString() || key == "device_status" || strings.HasSuffix(key, "running_status") || strings.HasPrefix(key, "alarm_") {
+			sensor.EntityCategory = "diagnostic"
+		}
+
+		topic := fmt.Sprintf("%s/sensor/%s/%s/config", cfg.HomeAssistant.DiscoveryPrefix, nodeID, key)
+		configs[topic] = sensor
+	}
+
+	return configs
+}
+
+func publishHADiscovery(mc mqtt.Client, cfg *LoadedConfig, dev *device, d *solar.Sample) error {
+	for topic, sensor := range haSensorConfigs(cfg, dev, d) {
+		payload, err := json.Marshal(sensor)
+		if err != nil {
+			return fmt.Errorf("marshal discovery config for %s: %v", topic, err)
+		}
+
+		// discovery configs are always retained so HA picks them up after it restarts
+		token := mc.Publish(topic, cfg.MQTT.QoS, true, payload)
+		if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+			return fmt.Errorf("publish discovery config to %s: %v", topic, token.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
+)
+
+func TestHAFriendlyName(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"pv1_voltage_v", "PV1 voltage"},
+		{"meter_grid_a_voltage_v", "Meter grid A voltage"},
+		{"cum_energy_yield_kwh", "Cumulative energy yield"},
+		{"battery_soc_percent", "Battery SOC"},
+		{"meter_reactive_power_w", "Meter reactive power"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := haFriendlyName(tt.key, haClassFor(tt.key)); got != tt.want {
+				t.Errorf("haFriendlyName(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHASensorConfigs(t *testing.T) {
+	cfg := &LoadedConfig{}
+	cfg.MQTT.AvailabilityTopic = "solar/availability"
+	cfg.HomeAssistant.DiscoveryPrefix = "homeassistant"
+	inv := &inverter{availabilityTopic: "solar/inverter/availability"}
+
+	tests := []struct {
+		name       string
+		dev        *device
+		fields     []solar.Field
+		wantNodeID string
+		wantName   string
+	}{
+		{
+			name: "serial",
+			dev:  &device{inverter: inv, topic: "solar"},
+			fields: []solar.Field{
+				{Key: "model_name", Value: "SUN2000-5KTL"},
+				{Key: "serial_number", Value: "HV 123"},
+			},
+			wantNodeID: "HV_123",
+			wantName:   "Huawei SUN2000-5KTL",
+		},
+		{
+			name:       "named device without a serial",
+			dev:        &device{name: "meter", inverter: inv, topic: "solar/meter"},
+			wantNodeID: "meter",
+			wantName:   "meter",
+		},
+		{
+			name:       "unnamed device without a serial",
+			dev:        &device{inverter: inv, topic: "solar/house"},
+			wantNodeID: "solar_house",
+			wantName:   "Huawei",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := append(tt.fields,
+				solar.Field{Key: "pv_input_power_w", Value: 1500.0},
+				solar.Field{Key: "device_status", Value: 512.0},
+				solar.Field{Key: "alarms", Value: []solar.Alarm{}},
+			)
+			configs := haSensorConfigs(cfg, tt.dev, &solar.Sample{Fields: fields})
+
+			if len(configs) != len(fields)-1 {
+				t.Errorf("got %d configs, want one per field except alarms", len(configs))
+			}
+
+			power, ok := configs["homeassistant/sensor/"+tt.wantNodeID+"/pv_input_power_w/config"]
+			if !ok {
+				t.Fatalf("no config for pv_input_power_w under node %q, got %v", tt.wantNodeID, configs)
+			}
+			if power.UniqueID != "huawei_solar_"+tt.wantNodeID+"_pv_input_power_w" || power.Device.Identifiers[0] != "huawei_solar_"+tt.wantNodeID {
+				t.Errorf("ids = %q, %q", power.UniqueID, power.Device.Identifiers)
+			}
+			if power.Device.Name != tt.wantName {
+				t.Errorf("device name = %q, want %q", power.Device.Name, tt.wantName)
+			}
+			if power.StateTopic != tt.dev.topic || power.DeviceClass != "power" || power.UnitOfMeasurement != "W" || power.EntityCategory != "" {
+				t.Errorf("power config = %+v", power)
+			}
+			if len(power.Availability) != 2 || power.Availability[1].Topic != inv.availabilityTopic {
+				t.Errorf("availability = %+v", power.Availability)
+			}
+
+			status := configs["homeassistant/sensor/"+tt.wantNodeID+"/device_status/config"]
+			if status.EntityCategory != "diagnostic" {
+				t.Errorf("device_status entity category = %q, want diagnostic", status.EntityCategory)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package mock
+
+import (
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+)
+
+// FC 0x2B / MEI 0x0E, read device identification.
+// The inverter answers object 0x87 with the number of devices, followed by a 0x88 description object per device.
+// The count is sent on its own with "more follows", so clients have to page through to the description.
+func (s *Server) handleDeviceInfo(data []byte) ([]byte, modbus.ExceptionCode) {
+	if len(data) < 3 || data[0] != 0x0e {
+		return nil, modbus.ExceptionIllegalDataValue
+	}
+	readDevIdCode := data[1]
+	objectId := data[2]
+
+	var objs [][]byte
+	more, next := byte(0x00), byte(0x00)
+
+	switch objectId {
+	case 0x87:
+		objs = append(objs, []byte{0x87, 1, 1})
+		more, next = 0xFF, 0x88
+	case 0x88:
+		desc := []byte(s.DeviceInfo)
+		objs = append(objs, append([]byte{0x88, byte(len(desc))}, desc...))
+	default:
+		return nil, modbus.ExceptionIllegalDataAddress
+	}
+
+	resp := []byte{
+		0x0e,
+		readDevIdCode,
+		0x01, // conformity level
+		more,
+		next,
+		byte(len(objs)),
+	}
+	for _, obj := range objs {
+		resp = append(resp, obj...)
+	}
+	return resp, 0
+}
@@ -0,0 +1,57 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+)
+
+func (s *Server) ListenAndServeHello(ctx context.Context, addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("mock: failed to listen for hello on %s: %v", addr, err)
+	}
+	return s.ServeHello(ctx, conn)
+}
+
+// ServeHello answers the "ZZZZ" discovery broadcast that solar.BroadcastHello sends to UDP 6600
+func (s *Server) ServeHello(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	slog.Info("mock inverter listening for hello", "addr", conn.LocalAddr())
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("mock: hello read failed: %v", err)
+		}
+
+		packet := buf[:n]
+		if len(packet) < 12 || !bytes.HasPrefix(packet, []byte("ZZZZ")) {
+			slog.Debug("mock ignoring non-hello packet", "from", from, "size", n)
+			continue
+		}
+
+		slog.Info("mock received hello", "from", from, "client_ip", net.IP(packet[8:12]))
+
+		_, err = conn.WriteTo(s.helloResponse(), from)
+		if err != nil {
+			slog.Warn("mock failed to answer hello", "err", err)
+		}
+	}
+}
+
+// The real response format isn't known beyond the "ZZZZ" magic, so answer with the magic and our device description
+func (s *Server) helloResponse() []byte {
+	resp := []byte{'Z', 'Z', 'Z', 'Z', 0, 65, 59, byte(len(s.DeviceInfo))}
+	return append(resp, []byte(s.DeviceInfo)...)
+}
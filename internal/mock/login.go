@@ -0,0 +1,105 @@
+package mock
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"log/slog"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+)
+
+// same codes as solar.loginFailureCodes
+const (
+	loginStatusOK            = 0
+	loginStatusFailed        = 1
+	loginStatusUnknownUser   = 2
+	loginStatusWrongPassword = 6
+)
+
+// same as solar.loginHash, duplicated so the mock doesn't just trust the client's implementation
+func loginHash(password string, challenge []byte) []byte {
+	k := sha256.Sum256([]byte(password))
+	mac := hmac.New(sha256.New, k[:])
+	mac.Write(challenge)
+	return mac.Sum(nil)
+}
+
+func (s *Server) handleLogin(sess *session, data []byte) ([]byte, modbus.ExceptionCode) {
+	if len(data) < 1 {
+		return nil, modbus.ExceptionIllegalDataValue
+	}
+
+	switch data[0] {
+	case 0x24:
+		// login part 1: hand out a fresh challenge
+		sess.challenge = make([]byte, 16)
+		rand.Read(sess.challenge)
+		sess.loggedIn = false
+
+		resp := []byte{0x24, byte(len(sess.challenge))}
+		return append(resp, sess.challenge...), 0
+
+	case 0x25:
+		return s.handleLoginResponse(sess, data)
+	}
+
+	return nil, modbus.ExceptionIllegalDataValue
+}
+
+// login part 2: subcmd, len, client challenge (16), username len, username, hash len, hash
+func (s *Server) handleLoginResponse(sess *session, data []byte) ([]byte, modbus.ExceptionCode) {
+	if sess.challenge == nil {
+		return loginResult(loginStatusFailed, nil), 0
+	}
+	challenge := sess.challenge
+	sess.challenge = nil
+
+	if len(data) < 2+17 {
+		return nil, modbus.ExceptionIllegalDataValue
+	}
+	cursor := data[2:]
+	clientChallenge := cursor[:16]
+	cursor = cursor[16:]
+
+	userLen := int(cursor[0])
+	if len(cursor) < 1+userLen+1 {
+		return nil, modbus.ExceptionIllegalDataValue
+	}
+	username := string(cursor[1 : 1+userLen])
+	cursor = cursor[1+userLen:]
+
+	hashLen := int(cursor[0])
+	if len(cursor) < 1+hashLen {
+		return nil, modbus.ExceptionIllegalDataValue
+	}
+	hash := cursor[1 : 1+hashLen]
+
+	if username != s.Username {
+		slog.Info("mock login rejected, unknown user", "username", username)
+		return loginResult(loginStatusUnknownUser, nil), 0
+	}
+	if !bytes.Equal(hash, loginHash(s.Password, challenge)) {
+		slog.Info("mock login rejected, wrong password", "username", username)
+		return loginResult(loginStatusWrongPassword, nil), 0
+	}
+
+	slog.Info("mock login accepted", "username", username)
+	sess.loggedIn = true
+	if s.SpoofLoginMAC {
+		return loginResult(loginStatusOK, loginHash("not the password", clientChallenge)), 0
+	}
+	return loginResult(loginStatusOK, loginHash(s.Password, clientChallenge)), 0
+}
+
+// same layout as a real SUN2000: subcmd, len, 1, mac len, mac, status, 55.
+// Failed logins still get a (zeroed) mac, since the status comes after it.
+func loginResult(status byte, mac []byte) []byte {
+	if mac == nil {
+		mac = make([]byte, sha256.Size)
+	}
+	resp := []byte{0x25, byte(2 + len(mac) + 2), 1, byte(len(mac))}
+	resp = append(resp, mac...)
+	return append(resp, status, 55)
+}
@@ -0,0 +1,327 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+	"gopkg.in/yaml.v3"
+)
+
+// Register is one value in the mock's register map
+type Register struct {
+	Addr uint16 `yaml:"addr"`
+	// i16/u16/i32/u32/i64/u64/f32/f64 or string
+	Type  string `yaml:"type"`
+	Value string `yaml:"value"`
+	// Length in bytes, only for strings
+	Len uint16 `yaml:"len"`
+}
+
+func (r Register) encode() ([]byte, error) {
+	var buff bytes.Buffer
+	var err error
+
+	switch r.Type {
+	case "string":
+		if int(r.Len) < len(r.Value) {
+			return nil, fmt.Errorf("string %q is longer than len %d", r.Value, r.Len)
+		}
+		buff.WriteString(r.Value)
+		buff.Write(make([]byte, int(r.Len)-len(r.Value)))
+
+	case "i16", "int16":
+		var v int64
+		v, err = strconv.ParseInt(r.Value, 10, 16)
+		binary.Write(&buff, binary.BigEndian, int16(v))
+	case "u16", "uint16":
+		var v uint64
+		v, err = strconv.ParseUint(r.Value, 10, 16)
+		binary.Write(&buff, binary.BigEndian, uint16(v))
+	case "i32", "int32":
+		var v int64
+		v, err = strconv.ParseInt(r.Value, 10, 32)
+		binary.Write(&buff, binary.BigEndian, int32(v))
+	case "u32", "uint32":
+		var v uint64
+		v, err = strconv.ParseUint(r.Value, 10, 32)
+		binary.Write(&buff, binary.BigEndian, uint32(v))
+	case "i64", "int64":
+		var v int64
+		v, err = strconv.ParseInt(r.Value, 10, 64)
+		binary.Write(&buff, binary.BigEndian, v)
+	case "u64", "uint64":
+		var v uint64
+		v, err = strconv.ParseUint(r.Value, 10, 64)
+		binary.Write(&buff, binary.BigEndian, v)
+	case "f32", "float32":
+		var v float64
+		v, err = strconv.ParseFloat(r.Value, 32)
+		binary.Write(&buff, binary.BigEndian, math.Float32bits(float32(v)))
+	case "f64", "float64":
+		var v float64
+		v, err = strconv.ParseFloat(r.Value, 64)
+		binary.Write(&buff, binary.BigEndian, math.Float64bits(v))
+
+	default:
+		return nil, fmt.Errorf("unknown register type %q", r.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %v", r.Type, r.Value, err)
+	}
+
+	if buff.Len()%2 != 0 {
+		buff.WriteByte(0)
+	}
+	return buff.Bytes(), nil
+}
+
+// SetRegisters encodes and stores each register, overwriting anything already there
+func (s *Server) SetRegisters(regs []Register) error {
+	s.registersMu.Lock()
+	defer s.registersMu.Unlock()
+
+	for _, r := range regs {
+		b, err := r.encode()
+		if err != nil {
+			return fmt.Errorf("mock: register %d: %v", r.Addr, err)
+		}
+		for i := 0; i < len(b); i += 2 {
+			s.registers[r.Addr+uint16(i/2)] = binary.BigEndian.Uint16(b[i:])
+		}
+	}
+	return nil
+}
+
+// SetUnsupported makes any read that touches these addresses fail with an illegal data address,
+// like a register in the middle of a block that this model doesn't have
+func (s *Server) SetUnsupported(addrs ...uint16) {
+	s.registersMu.Lock()
+	defer s.registersMu.Unlock()
+
+	for _, addr := range addrs {
+		s.unsupported[addr] = true
+	}
+}
+
+// Registers returns the raw u16 value of quantity registers starting at address, unset registers read as 0
+func (s *Server) Registers(address, quantity uint16) []uint16 {
+	s.registersMu.Lock()
+	defer s.registersMu.Unlock()
+
+	values := make([]uint16, quantity)
+	for i := range values {
+		values[i] = s.registers[address+uint16(i)]
+	}
+	return values
+}
+
+func LoadRegisters(path string) ([]Register, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var regs []Register
+	if err := yaml.Unmarshal(b, &regs); err != nil {
+		return nil, err
+	}
+	return regs, nil
+}
+
+// hasAnyRegister is used to reject reads of ranges that don't exist at all, or touch an unsupported register.
+// Unset registers inside a range that is partially set read as 0, like the real inverter's reserved registers.
+func (s *Server) hasAnyRegister(address, quantity uint16) bool {
+	s.registersMu.Lock()
+	defer s.registersMu.Unlock()
+
+	found := false
+	for i := uint16(0); i < quantity; i++ {
+		if s.unsupported[address+i] {
+			return false
+		}
+		if _, ok := s.registers[address+i]; ok {
+			found = true
+		}
+	}
+	return found
+}
+
+func (s *Server) handleReadHoldingRegisters(data []byte) ([]byte, modbus.ExceptionCode) {
+	if len(data) != 4 {
+		return nil, modbus.ExceptionIllegalDataValue
+	}
+	address := binary.BigEndian.Uint16(data[0:2])
+	quantity := binary.BigEndian.Uint16(data[2:4])
+
+	if quantity < 1 || quantity > 125 {
+		return nil, modbus.ExceptionIllegalDataValue
+	}
+	if !s.hasAnyRegister(address, quantity) {
+		return nil, modbus.ExceptionIllegalDataAddress
+	}
+	for _, reg := range writeOnlyRegisters {
+		if reg >= address && reg < address+quantity {
+			return nil, modbus.ExceptionIllegalDataAddress
+		}
+	}
+
+	var buff bytes.Buffer
+	buff.WriteByte(byte(quantity * 2))
+	binary.Write(&buff, binary.BigEndian, s.Registers(address, quantity))
+	return buff.Bytes(), 0
+}
+
+func (s *Server) handleWriteSingleRegister(data []byte) ([]byte, modbus.ExceptionCode) {
+	if len(data) != 4 {
+		return nil, modbus.ExceptionIllegalDataValue
+	}
+	address := binary.BigEndian.Uint16(data[0:2])
+	if !s.hasAnyRegister(address, 1) {
+		return nil, modbus.ExceptionIllegalDataAddress
+	}
+
+	s.registersMu.Lock()
+	s.registers[address] = binary.BigEndian.Uint16(data[2:4])
+	s.applyControlLocked(address, 1)
+	s.registersMu.Unlock()
+
+	// response is an echo of the request
+	return data, 0
+}
+
+func (s *Server) handleWriteMultipleRegisters(data []byte) ([]byte, modbus.ExceptionCode) {
+	if len(data) < 5 {
+		return nil, modbus.ExceptionIllegalDataValue
+	}
+	address := binary.BigEndian.Uint16(data[0:2])
+	quantity := binary.BigEndian.Uint16(data[2:4])
+	byteCount := int(data[4])
+	values := data[5:]
+
+	if quantity < 1 || quantity > 123 || byteCount != int(quantity)*2 || len(values) != byteCount {
+		return nil, modbus.ExceptionIllegalDataValue
+	}
+	if !s.hasAnyRegister(address, quantity) {
+		return nil, modbus.ExceptionIllegalDataAddress
+	}
+
+	s.registersMu.Lock()
+	for i := uint16(0); i < quantity; i++ {
+		s.registers[address+i] = binary.BigEndian.Uint16(values[i*2:])
+	}
+	s.applyControlLocked(address, quantity)
+	s.registersMu.Unlock()
+
+	return data[0:4], 0
+}
+
+// DefaultRegisters is a plausible snapshot of a SUN2000 on a sunny afternoon, covering everything solar.Client reads or writes
+func DefaultRegisters() []Register {
+	return []Register{
+		{Addr: 30000, Type: "string", Len: 30, Value: "SUN2000-10KTL-M1"},
+		{Addr: 30015, Type: "string", Len: 20, Value: "MOCK000000001"},
+		{Addr: 30073, Type: "u32", Value: "10000"}, // rated power
+
+		// no active alarms
+		{Addr: 32008, Type: "u16", Value: "0"},
+		{Addr: 32009, Type: "u16", Value: "0"},
+		{Addr: 32010, Type: "u16", Value: "0"},
+
+		{Addr: 32016, Type: "i16", Value: "3850"},
+		{Addr: 32017, Type: "i16", Value: "712"},
+		{Addr: 32018, Type: "i16", Value: "3790"},
+		{Addr: 32019, Type: "i16", Value: "690"},
+		{Addr: 32020, Type: "i16", Value: "0"},
+		{Addr: 32021, Type: "i16", Value: "0"},
+
+		{Addr: 32064, Type: "i32", Value: "5357"},
+		{Addr: 32066, Type: "u16", Value: "2405"},
+		{Addr: 32080, Type: "i32", Value: "5190"},
+		{Addr: 32082, Type: "i32", Value: "120"},
+		{Addr: 32085, Type: "u16", Value: "5002"},
+		{Addr: 32087, Type: "i16", Value: "452"},
+		{Addr: 32089, Type: "u16", Value: "512"}, // 0x0200, on-grid
+
+		{Addr: 32106, Type: "u32", Value: "2433332"},
+		{Addr: 32114, Type: "u32", Value: "2875"},
+
+		{Addr: 32212, Type: "u32", Value: "1234567"},
+		{Addr: 32214, Type: "u32", Value: "1198765"},
+		{Addr: 32216, Type: "u32", Value: "0"},
+
+		{Addr: 37101, Type: "i32", Value: "2401"},
+		{Addr: 37103, Type: "i32", Value: "0"},
+		{Addr: 37105, Type: "i32", Value: "0"},
+		{Addr: 37113, Type: "i32", Value: "-3200"},
+		{Addr: 37115, Type: "i32", Value: "80"},
+		{Addr: 37118, Type: "i16", Value: "5001"},
+		{Addr: 37119, Type: "i32", Value: "1523411"},
+		{Addr: 37121, Type: "i32", Value: "876502"},
+		{Addr: 37132, Type: "i32", Value: "3200"},
+
+		// a single LUNA2000 unit, charging
+		{Addr: 37000, Type: "u16", Value: "2"},
+		{Addr: 37001, Type: "i32", Value: "1500"},
+		{Addr: 37003, Type: "u16", Value: "4512"},
+		{Addr: 37004, Type: "u16", Value: "653"},
+		{Addr: 37015, Type: "u32", Value: "420"},
+		{Addr: 37017, Type: "u32", Value: "310"},
+		{Addr: 37066, Type: "u32", Value: "123456"},
+		{Addr: 37068, Type: "u32", Value: "112233"},
+		{Addr: 37760, Type: "u16", Value: "653"},
+		{Addr: 37762, Type: "u16", Value: "2"},
+		{Addr: 37763, Type: "u16", Value: "4512"},
+		{Addr: 37765, Type: "i32", Value: "1500"},
+		{Addr: 37780, Type: "u32", Value: "123456"},
+		{Addr: 37782, Type: "u32", Value: "112233"},
+		{Addr: 37784, Type: "u32", Value: "420"},
+		{Addr: 37786, Type: "u32", Value: "310"},
+		{Addr: 47000, Type: "u16", Value: "2"}, // LUNA2000
+		{Addr: 47075, Type: "u32", Value: "5000"},
+		{Addr: 47077, Type: "u32", Value: "5000"},
+		{Addr: 47083, Type: "u16", Value: "0"},
+		{Addr: 47086, Type: "u16", Value: "2"}, // maximise self consumption
+		{Addr: 47087, Type: "u16", Value: "0"},
+		{Addr: 47089, Type: "u16", Value: "0"},
+		{Addr: 47100, Type: "u16", Value: "0"},
+		{Addr: 47246, Type: "u16", Value: "0"},
+		{Addr: 47247, Type: "u32", Value: "0"},
+		{Addr: 47249, Type: "u32", Value: "0"},
+
+		// control registers, so writes have somewhere to go
+		{Addr: 40125, Type: "i16", Value: "1000"},
+		{Addr: 40126, Type: "u32", Value: "10000"},
+		{Addr: 40200, Type: "u16", Value: "0"},
+		{Addr: 40201, Type: "u16", Value: "0"},
+		{Addr: 47415, Type: "u16", Value: "0"},
+		{Addr: 47416, Type: "i32", Value: "0"},
+		{Addr: 47418, Type: "i16", Value: "1000"},
+	}
+}
+
+const (
+	regDeviceStatus           = 32089
+	regStartup                = 40200
+	regShutdown               = 40201
+	regBatteryForcibleCommand = 47100
+)
+
+// writeOnlyRegisters are command registers, which the real inverter won't let you read
+var writeOnlyRegisters = []uint16{regStartup, regShutdown, regBatteryForcibleCommand}
+
+// applyControlLocked makes the device status follow start-up/shutdown commands, as the real inverter would (eventually)
+func (s *Server) applyControlLocked(address, quantity uint16) {
+	for a := address; a < address+quantity; a++ {
+		switch a {
+		case regStartup:
+			s.registers[regDeviceStatus] = 0x0200 // on-grid
+		case regShutdown:
+			s.registers[regDeviceStatus] = 0x0301 // shutdown, command
+		}
+	}
+}
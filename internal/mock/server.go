@@ -0,0 +1,149 @@
+// Package mock is a fake Huawei SUN2000 inverter, for tests and local development without real hardware
+package mock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+)
+
+type Server struct {
+	Username string
+	Password string
+	// If set, register reads and writes are rejected until the connection has logged in
+	RequireLogin bool
+	// key=value;... description returned in device info object 0x88
+	DeviceInfo string
+	// Unit IDs to answer as, like a cascade behind an SDongle. Every unit serves the same registers.
+	// Others get a gateway target failed exception. Empty answers every unit ID.
+	UnitIDs []uint8
+	// If set, logins are answered with a made up mac, like something else on the network pretending to be the inverter
+	SpoofLoginMAC bool
+
+	registersMu sync.Mutex
+	registers   map[uint16]uint16
+	unsupported map[uint16]bool
+}
+
+func NewServer() *Server {
+	return &Server{
+		DeviceInfo:  "1=SUN2000-10KTL-M1;2=V100R001C00SPC141;3=V2.0;4=MOCK000000001;5=0;6=127106;8=410",
+		registers:   make(map[uint16]uint16),
+		unsupported: make(map[uint16]bool),
+	}
+}
+
+// session is the state of a single modbus tcp connection
+type session struct {
+	loggedIn  bool
+	challenge []byte
+}
+
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("mock: failed to listen on %s: %v", addr, err)
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve accepts modbus tcp connections until ctx is done
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	slog.Info("mock inverter listening", "addr", ln.Addr())
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("mock: accept failed: %v", err)
+		}
+
+		go func() {
+			err := s.serveConn(ctx, conn)
+			if err != nil && !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				slog.Warn("mock connection finished", "remote", conn.RemoteAddr(), "err", err)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	slog.Debug("mock accepted connection", "remote", conn.RemoteAddr())
+	sess := &session{}
+
+	for {
+		req := &modbus.ModbusTCPADU{}
+		err := req.Scan(conn)
+		if err != nil {
+			return err
+		}
+
+		respData, exc := s.handle(sess, req)
+		resp := &modbus.ModbusTCPADU{
+			ModbusMBAPHeader: modbus.ModbusMBAPHeader{
+				TransactionID: req.TransactionID,
+				UnitID:        req.UnitID,
+			},
+			FunctionCode: req.FunctionCode,
+			Data:         respData,
+		}
+		if exc != 0 {
+			resp.FunctionCode |= 0x80
+			resp.Data = []byte{byte(exc)}
+		}
+		resp.Length = uint16(len(resp.Data) + 2)
+
+		_, err = conn.Write(resp.Marshal())
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(sess *session, req *modbus.ModbusTCPADU) ([]byte, modbus.ExceptionCode) {
+	slog.Debug("mock handling request", "function_code", req.FunctionCode, "data", fmt.Sprintf("%v", req.Data))
+
+	if len(s.UnitIDs) > 0 && !slices.Contains(s.UnitIDs, req.UnitID) {
+		return nil, modbus.ExceptionGatewayTargetFailed
+	}
+
+	switch req.FunctionCode {
+	case 0x03, 0x06, 0x10:
+		if s.RequireLogin && !sess.loggedIn {
+			return nil, modbus.ExceptionPermissionDenied
+		}
+	}
+
+	switch req.FunctionCode {
+	case 0x03:
+		return s.handleReadHoldingRegisters(req.Data)
+	case 0x06:
+		return s.handleWriteSingleRegister(req.Data)
+	case 0x10:
+		return s.handleWriteMultipleRegisters(req.Data)
+	case 0x2B:
+		return s.handleDeviceInfo(req.Data)
+	case 0x41:
+		return s.handleLogin(sess, req.Data)
+	}
+
+	return nil, modbus.ExceptionIllegalFunction
+}
@@ -0,0 +1,20 @@
+package modbus
+
+// Bitfield is a register (or pair of registers) where each bit is a separate flag.
+// Use it as a struct field with modbus_type "u16" or "u32", or as type "bitfield16"/"bitfield32" in a RegisterSpec.
+type Bitfield uint32
+
+func (b Bitfield) IsSet(bit int) bool {
+	return b&(1<<bit) != 0
+}
+
+// SetBits lists the index of every set bit, lowest first
+func (b Bitfield) SetBits() []int {
+	bits := []int{}
+	for i := 0; i < 32; i++ {
+		if b.IsSet(i) {
+			bits = append(bits, i)
+		}
+	}
+	return bits
+}
@@ -12,10 +12,18 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// ModbusConn talks to a single unit (slave) ID over a shared transport, see WithUnit
 type ModbusConn struct {
-	conn    net.Conn
-	txId    *atomic.Uint32
+	*transport
+
 	slaveId uint8
+	readGap uint16
+}
+
+// transport is the TCP connection itself, shared between every unit on it
+type transport struct {
+	conn net.Conn
+	txId *atomic.Uint32
 
 	aduRxCh chan *ModbusTCPADU
 	aduTxCh chan *ModbusTCPADU
@@ -31,16 +39,33 @@ func NewModbusConn(conn net.Conn, slaveId uint8) *ModbusConn {
 	txId.Store(1234)
 
 	return &ModbusConn{
-		conn:    conn,
-		txId:    &txId,
+		transport: &transport{
+			conn: conn,
+			txId: &txId,
+
+			aduRxCh: make(chan *ModbusTCPADU),
+			aduTxCh: make(chan *ModbusTCPADU),
+			waiters: make(map[uint16]chan *ModbusTCPADU),
+		},
 		slaveId: slaveId,
+		readGap: defaultReadGap,
+	}
+}
 
-		aduRxCh: make(chan *ModbusTCPADU),
-		aduTxCh: make(chan *ModbusTCPADU),
-		waiters: make(map[uint16]chan *ModbusTCPADU),
+// WithUnit returns a ModbusConn for another unit ID on the same connection, i.e. a cascaded inverter or meter behind
+// an SDongle or SmartLogger. Only one of them should be Run, and closing any of them closes the connection for all.
+func (c *ModbusConn) WithUnit(unitID uint8) *ModbusConn {
+	return &ModbusConn{
+		transport: c.transport,
+		slaveId:   unitID,
+		readGap:   c.readGap,
 	}
 }
 
+func (c *ModbusConn) UnitID() uint8 {
+	return c.slaveId
+}
+
 func (c *ModbusConn) Close() error {
 	return c.conn.Close()
 }
@@ -49,6 +74,11 @@ func (c *ModbusConn) SetConn(conn net.Conn) {
 	c.conn = conn
 }
 
+// SetReadGap sets how many unused registers may sit between two values before they're read in separate calls
+func (c *ModbusConn) SetReadGap(gap uint16) {
+	c.readGap = gap
+}
+
 func (c *ModbusConn) Run(parentCtx context.Context) error {
 	ok := c.runningMu.TryLock()
 	if !ok {
@@ -144,19 +174,24 @@ func (c *ModbusConn) waiter(transactionID uint16) chan *ModbusTCPADU {
 }
 
 func (c *ModbusConn) FunctionCall(ctx context.Context, fc uint8, data []byte) (*ModbusTCPADU, error) {
+	return c.FunctionCallUnit(ctx, c.slaveId, fc, data)
+}
+
+// FunctionCallUnit is FunctionCall, but to a specific unit ID rather than the one the ModbusConn is for
+func (c *ModbusConn) FunctionCallUnit(ctx context.Context, unitID uint8, fc uint8, data []byte) (*ModbusTCPADU, error) {
 	transactionID := uint16(c.txId.Add(1))
 	req := &ModbusTCPADU{
 		ModbusMBAPHeader: ModbusMBAPHeader{
 			TransactionID: uint16(transactionID),
 			ProtocolID:    0x0000,
 			Length:        uint16(len(data) + 2), // unit id + fc
-			UnitID:        c.slaveId,
+			UnitID:        unitID,
 		},
 		FunctionCode: fc,
 		Data:         data,
 	}
 
-	slog.Debug("sending modbus function call", "transaction_id", transactionID, "function_code", fc, "data", fmt.Sprintf("%v", data))
+	slog.Debug("sending modbus function call", "transaction_id", transactionID, "unit_id", unitID, "function_code", fc, "data", fmt.Sprintf("%v", data))
 	resultCh := c.waiter(transactionID)
 
 	select {
@@ -171,6 +206,9 @@ func (c *ModbusConn) FunctionCall(ctx context.Context, fc uint8, data []byte) (*
 		return nil, fmt.Errorf("modbus waiting to receive response: %v", ctx.Err())
 
 	case result := <-resultCh:
+		if err := exceptionFromADU(result); err != nil {
+			return nil, err
+		}
 		return result, nil
 	}
 }
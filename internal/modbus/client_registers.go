@@ -44,7 +44,7 @@ func (c *ModbusConn) ReadHoldingRegistersU16(ctx context.Context, address, quant
 
 	resp, err := c.FunctionCall(ctx, 0x03, buff.Bytes())
 	if err != nil {
-		return nil, fmt.Errorf("modbus: failed to make call to read holding registers: %v", err)
+		return nil, fmt.Errorf("modbus: failed to make call to read holding registers: %w", err)
 	}
 
 	if len(resp.Data) == 0 {
@@ -145,6 +145,9 @@ func (c *ModbusConn) QueryStructRegisters(ctx context.Context, d interface{}) er
 	v := reflect.ValueOf(d).Elem()
 	st := v.Type()
 
+	specs := []RegisterSpec{}
+	fieldIndices := []int{}
+
 	for i := 0; i < v.NumField(); i++ {
 		field := v.Field(i)
 		fieldType := st.Field(i)
@@ -162,10 +165,6 @@ func (c *ModbusConn) QueryStructRegisters(ctx context.Context, d interface{}) er
 		if err != nil {
 			return fmt.Errorf("field %q has an invalid modbus_scalar tag: %v", fieldType.Name, err)
 		}
-		// note, the listed scalar was what the *original* scalar was
-		// i.e., "230.1" stored as "2301" has a scalar of 10
-		// so we *divide* by said scalar
-		scalar = 1.0 / scalar
 
 		outputType := fieldType.Tag.Get("modbus_type")
 		if outputType == "" {
@@ -177,8 +176,14 @@ func (c *ModbusConn) QueryStructRegisters(ctx context.Context, d interface{}) er
 			return fmt.Errorf("field %q has an invalid modbus_addr tag: %v", fieldType.Name, err)
 		}
 
-		switch field.Type().Kind() {
-		case reflect.String:
+		spec := RegisterSpec{
+			Name:    fieldType.Name,
+			Address: uint16(addr),
+			Type:    outputType,
+			Scalar:  scalar,
+		}
+
+		if field.Type().Kind() == reflect.String {
 			strLenStr := fieldType.Tag.Get("modbus_str_len")
 			strLen, err := strconv.ParseInt(strLenStr, 10, 16)
 
@@ -186,28 +191,46 @@ func (c *ModbusConn) QueryStructRegisters(ctx context.Context, d interface{}) er
 				return fmt.Errorf("field %q is a string, but does not have a valid modbus_str_len tag", fieldType.Name)
 			}
 
-			strOut, err := ReadHoldingRegisterString(c, ctx, uint16(addr), uint16(strLen))
-			if err != nil {
-				return fmt.Errorf("failed to read %q (%s): %v", fieldType.Name, fieldType.Type.Name(), err)
-			}
-			field.SetString(strOut)
+			spec.Type = "string"
+			spec.StrLen = uint16(strLen)
+		}
 
-		default:
-			result := anyNumByName(outputType)
-			err := ReadHoldingRegisterAny(c, ctx, uint16(addr), result)
-			if err != nil {
-				return fmt.Errorf("failed to read %q (%s): %v", fieldType.Name, fieldType.Type.Name(), err)
-			}
+		specs = append(specs, spec)
+		fieldIndices = append(fieldIndices, i)
+	}
 
-			if field.CanInt() {
-				field.SetInt(castAnyNumTo[int64](result) * int64(scalar))
-			} else if field.CanFloat() {
-				field.SetFloat(castAnyNumTo[float64](result) * scalar)
-			} else if field.CanUint() {
-				field.SetUint(castAnyNumTo[uint64](result) * uint64(scalar))
-			} else {
-				return fmt.Errorf("can't set field %q (%s), its neither int, float, nor uint", fieldType.Name, fieldType.Type.Name())
-			}
+	results, err := c.ReadRegisterSpecs(ctx, specs)
+	if err != nil {
+		return err
+	}
+
+	for j, i := range fieldIndices {
+		field := v.Field(i)
+		fieldType := st.Field(i)
+
+		// register doesn't exist on this model, leave it empty
+		if results[j] == nil {
+			continue
+		}
+
+		if str, ok := results[j].(string); ok {
+			field.SetString(str)
+			continue
+		}
+
+		// note, the listed scalar was what the *original* scalar was
+		// i.e., "230.1" stored as "2301" has a scalar of 10
+		// so we *divide* by said scalar
+		scalar := 1.0 / specs[j].Scalar
+
+		if field.CanInt() {
+			field.SetInt(castAnyNumTo[int64](results[j]) * int64(scalar))
+		} else if field.CanFloat() {
+			field.SetFloat(castAnyNumTo[float64](results[j]) * scalar)
+		} else if field.CanUint() {
+			field.SetUint(castAnyNumTo[uint64](results[j]) * uint64(scalar))
+		} else {
+			return fmt.Errorf("can't set field %q (%s), its neither int, float, nor uint", fieldType.Name, fieldType.Type.Name())
 		}
 	}
 
@@ -248,11 +271,11 @@ func anyNumByName(name string) any {
 		return new(uint8)
 	case "int16", "i16":
 		return new(int16)
-	case "uint16", "u16":
+	case "uint16", "u16", "bitfield16":
 		return new(uint16)
 	case "int32", "i32":
 		return new(int32)
-	case "uint32", "u32":
+	case "uint32", "u32", "bitfield32":
 		return new(uint32)
 	case "int64", "i64":
 		return new(int64)
@@ -265,3 +288,18 @@ func anyNumByName(name string) any {
 	}
 	panic("unknown type of number: " + name)
 }
+
+// sizeOfName returns the size in bytes of a numeric type name, or 0 if unknown
+func sizeOfName(name string) int {
+	switch name {
+	case "int8", "i8", "uint8", "u8":
+		return 1
+	case "int16", "i16", "uint16", "u16", "bitfield16":
+		return 2
+	case "int32", "i32", "uint32", "u32", "bitfield32", "float32", "f32":
+		return 4
+	case "int64", "i64", "uint64", "u64", "float64", "f64":
+		return 8
+	}
+	return 0
+}
@@ -0,0 +1,157 @@
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"math"
+	"reflect"
+)
+
+// WriteSingleRegister writes one u16 holding register (FC 0x06).
+// The slave echoes the request back, which we check.
+func (c *ModbusConn) WriteSingleRegister(ctx context.Context, address, value uint16) error {
+	var buff bytes.Buffer
+
+	binary.Write(&buff, binary.BigEndian, address)
+	binary.Write(&buff, binary.BigEndian, value)
+
+	resp, err := c.FunctionCall(ctx, 0x06, buff.Bytes())
+	if err != nil {
+		return fmt.Errorf("modbus: failed to make call to write single register: %w", err)
+	}
+
+	if len(resp.Data) != 4 {
+		return fmt.Errorf("modbus: write single register response has unexpected length '%d'", len(resp.Data))
+	}
+
+	echoAddr := binary.BigEndian.Uint16(resp.Data[0:2])
+	echoValue := binary.BigEndian.Uint16(resp.Data[2:4])
+	if echoAddr != address || echoValue != value {
+		return fmt.Errorf("modbus: write single register echoed address '%d' value '%d', expected '%d' '%d'", echoAddr, echoValue, address, value)
+	}
+
+	return nil
+}
+
+// WriteMultipleRegisters writes raw big endian register data (FC 0x10).
+// values must be a whole number of u16 registers.
+func (c *ModbusConn) WriteMultipleRegisters(ctx context.Context, address uint16, values []byte) error {
+	if len(values)%2 != 0 {
+		return fmt.Errorf("modbus: write data length '%d' is not a whole number of registers", len(values))
+	}
+
+	quantity := uint16(len(values) / 2)
+	if quantity < 1 || quantity > 123 {
+		return fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 123)
+	}
+
+	var buff bytes.Buffer
+
+	binary.Write(&buff, binary.BigEndian, address)
+	binary.Write(&buff, binary.BigEndian, quantity)
+	buff.WriteByte(byte(len(values)))
+	buff.Write(values)
+
+	resp, err := c.FunctionCall(ctx, 0x10, buff.Bytes())
+	if err != nil {
+		return fmt.Errorf("modbus: failed to make call to write multiple registers: %w", err)
+	}
+
+	if len(resp.Data) != 4 {
+		return fmt.Errorf("modbus: write multiple registers response has unexpected length '%d'", len(resp.Data))
+	}
+
+	echoAddr := binary.BigEndian.Uint16(resp.Data[0:2])
+	echoQuantity := binary.BigEndian.Uint16(resp.Data[2:4])
+	if echoAddr != address || echoQuantity != quantity {
+		return fmt.Errorf("modbus: write multiple registers echoed address '%d' quantity '%d', expected '%d' '%d'", echoAddr, echoQuantity, address, quantity)
+	}
+
+	return nil
+}
+
+// Encode is the reverse of Decode, giving the register data to write for a value of the spec's type.
+// The scalar is applied, and integers are rounded and range checked.
+func (s RegisterSpec) Encode(value float64) ([]byte, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	if s.IsString() {
+		return nil, fmt.Errorf("register %q is a string, only numbers can be encoded", s.Name)
+	}
+	if sizeOfName(s.Type) == 1 {
+		return nil, fmt.Errorf("register %q is %s, 8 bit values can't be written", s.Name, s.Type)
+	}
+	raw := value * s.Scalar
+	// NaN passes every range check below (and converts to whatever the platform likes), as does Inf for floats
+	if math.IsNaN(raw) || math.IsInf(raw, 0) {
+		return nil, fmt.Errorf("%v can't be written to %s", value, s.Type)
+	}
+	num := anyNumByName(s.Type)
+	v := reflect.ValueOf(num).Elem()
+
+	switch {
+	case v.CanFloat():
+		if v.OverflowFloat(raw) {
+			return nil, fmt.Errorf("%v is out of range for %s", value, s.Type)
+		}
+		v.SetFloat(raw)
+	case v.CanInt():
+		raw = math.Round(raw)
+		// float64(math.MaxInt64) rounds up to 2^63, which doesn't fit, hence >=
+		if raw < math.MinInt64 || raw >= math.MaxInt64 || v.OverflowInt(int64(raw)) {
+			return nil, fmt.Errorf("%v is out of range for %s", value, s.Type)
+		}
+		v.SetInt(int64(raw))
+	default:
+		raw = math.Round(raw)
+		if raw < 0 || raw >= math.MaxUint64 || v.OverflowUint(uint64(raw)) {
+			return nil, fmt.Errorf("%v is out of range for %s", value, s.Type)
+		}
+		v.SetUint(uint64(raw))
+	}
+
+	var buff bytes.Buffer
+	binary.Write(&buff, binary.BigEndian, num)
+	return buff.Bytes(), nil
+}
+
+// encodeRegisters is values as big endian register data. Registers are 16 bits, so i8/u8 can't be written:
+// there's no telling whether the device wants one in the high or low byte.
+func encodeRegisters[T numeric](values []T) ([]byte, error) {
+	if sizeOf[T]() == 1 {
+		return nil, fmt.Errorf("modbus: can't write 8 bit values, registers are 16 bits")
+	}
+
+	var buff bytes.Buffer
+	for _, v := range values {
+		binary.Write(&buff, binary.BigEndian, v)
+	}
+	return buff.Bytes(), nil
+}
+
+func WriteHoldingRegisters[T numeric](c *ModbusConn, ctx context.Context, address uint16, values []T) error {
+	b, err := encodeRegisters(values)
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("writing modbus holding registers", "address", address, "quantity", len(values), "total", len(b)/2)
+	return c.WriteMultipleRegisters(ctx, address, b)
+}
+
+// WriteHoldingRegister uses FC 0x06 when the value fits in one register, otherwise FC 0x10
+func WriteHoldingRegister[T numeric](c *ModbusConn, ctx context.Context, address uint16, value T) error {
+	b, err := encodeRegisters([]T{value})
+	if err != nil {
+		return err
+	}
+	if len(b) == 2 {
+		slog.Debug("writing modbus holding register", "address", address)
+		return c.WriteSingleRegister(ctx, address, binary.BigEndian.Uint16(b))
+	}
+	return WriteHoldingRegisters(c, ctx, address, []T{value})
+}
@@ -0,0 +1,152 @@
+package modbus_test
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"net"
+	"testing"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/mock"
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+)
+
+// dialResponder is a connection to a fake slave that answers every request with respond(request data)
+func dialResponder(t *testing.T, respond func(data []byte) []byte) *modbus.ModbusConn {
+	t.Helper()
+
+	client, server := net.Pipe()
+	go func() {
+		for {
+			req := &modbus.ModbusTCPADU{}
+			if err := req.Scan(server); err != nil {
+				return
+			}
+			resp := &modbus.ModbusTCPADU{
+				ModbusMBAPHeader: req.ModbusMBAPHeader,
+				FunctionCode:     req.FunctionCode,
+				Data:             respond(req.Data),
+			}
+			resp.Length = uint16(len(resp.Data) + 2)
+			server.Write(resp.Marshal())
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	mc := modbus.NewModbusConn(client, 1)
+	go mc.Run(ctx)
+	t.Cleanup(func() { mc.Close() })
+	return mc
+}
+
+func TestWriteSingleRegisterEcho(t *testing.T) {
+	tests := []struct {
+		name    string
+		respond func(data []byte) []byte
+		wantErr bool
+	}{
+		{name: "echo", respond: func(data []byte) []byte { return data }},
+		{name: "wrong address", respond: func(data []byte) []byte { return []byte{0x00, 0x65, data[2], data[3]} }, wantErr: true},
+		{name: "wrong value", respond: func(data []byte) []byte { return []byte{data[0], data[1], 0x00, 0x00} }, wantErr: true},
+		{name: "short", respond: func(data []byte) []byte { return data[:2] }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := dialResponder(t, tt.respond)
+
+			err := mc.WriteSingleRegister(context.Background(), 100, 0x1234)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("WriteSingleRegister() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteMultipleRegistersEcho(t *testing.T) {
+	tests := []struct {
+		name    string
+		respond func(data []byte) []byte
+		wantErr bool
+	}{
+		{name: "echo", respond: func(data []byte) []byte { return data[:4] }},
+		{name: "wrong address", respond: func(data []byte) []byte { return []byte{0x00, 0x65, data[2], data[3]} }, wantErr: true},
+		{name: "wrong quantity", respond: func(data []byte) []byte { return []byte{data[0], data[1], 0x00, 0x01} }, wantErr: true},
+		{name: "whole request echoed", respond: func(data []byte) []byte { return data }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := dialResponder(t, tt.respond)
+
+			err := mc.WriteMultipleRegisters(context.Background(), 100, []byte{0x00, 0x01, 0x00, 0x02})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("WriteMultipleRegisters() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteHoldingRegister(t *testing.T) {
+	srv := mock.NewServer()
+	setRegisters(t, srv,
+		mock.Register{Addr: 100, Type: "i16", Value: "0"},
+		mock.Register{Addr: 101, Type: "u32", Value: "0"},
+	)
+	mc := dialMock(t, srv)
+	ctx := context.Background()
+
+	err := modbus.WriteHoldingRegister(mc, ctx, 100, int16(-5))
+	if err != nil {
+		t.Fatalf("write i16: %v", err)
+	}
+	err = modbus.WriteHoldingRegister(mc, ctx, 101, uint32(70000))
+	if err != nil {
+		t.Fatalf("write u32: %v", err)
+	}
+	if got := srv.Registers(100, 3); got[0] != 0xfffb || got[1] != 0x0001 || got[2] != 0x1170 {
+		t.Errorf("registers after write = %04x", got)
+	}
+
+	err = modbus.WriteHoldingRegister(mc, ctx, 100, uint8(5))
+	if err == nil {
+		t.Errorf("writing a u8 should fail, registers are 16 bits")
+	}
+}
+
+func TestRegisterSpecEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    modbus.RegisterSpec
+		value   float64
+		want    []byte
+		wantErr bool
+	}{
+		{name: "scaled i16", spec: modbus.RegisterSpec{Type: "i16", Scalar: 10}, value: -1.5, want: []byte{0xff, 0xf1}},
+		{name: "rounded u32", spec: modbus.RegisterSpec{Type: "u32", Scalar: 1}, value: 70000.4, want: []byte{0x00, 0x01, 0x11, 0x70}},
+		{name: "f32", spec: modbus.RegisterSpec{Type: "f32", Scalar: 1}, value: 1, want: []byte{0x3f, 0x80, 0x00, 0x00}},
+		{name: "u16 negative", spec: modbus.RegisterSpec{Type: "u16", Scalar: 1}, value: -1, wantErr: true},
+		{name: "i16 overflow", spec: modbus.RegisterSpec{Type: "i16", Scalar: 10}, value: 3276.8, wantErr: true},
+		{name: "i64 2^63", spec: modbus.RegisterSpec{Type: "i64", Scalar: 1}, value: math.Pow(2, 63), wantErr: true},
+		{name: "u64 2^64", spec: modbus.RegisterSpec{Type: "u64", Scalar: 1}, value: math.Pow(2, 64), wantErr: true},
+		{name: "i32 NaN", spec: modbus.RegisterSpec{Type: "i32", Scalar: 1}, value: math.NaN(), wantErr: true},
+		{name: "f32 NaN", spec: modbus.RegisterSpec{Type: "f32", Scalar: 1}, value: math.NaN(), wantErr: true},
+		{name: "f64 Inf", spec: modbus.RegisterSpec{Type: "f64", Scalar: 1}, value: math.Inf(1), wantErr: true},
+		{name: "u8", spec: modbus.RegisterSpec{Type: "u8", Scalar: 1}, value: 5, wantErr: true},
+		{name: "string", spec: modbus.RegisterSpec{Type: "string", StrLen: 4, Scalar: 1}, value: 5, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.spec.Name = tt.name
+			got, err := tt.spec.Encode(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Encode(%v) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Encode(%v) = %x, want %x", tt.value, got, tt.want)
+			}
+		})
+	}
+}
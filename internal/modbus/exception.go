@@ -0,0 +1,73 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+)
+
+type ExceptionCode uint8
+
+const (
+	ExceptionIllegalFunction     ExceptionCode = 0x01
+	ExceptionIllegalDataAddress  ExceptionCode = 0x02
+	ExceptionIllegalDataValue    ExceptionCode = 0x03
+	ExceptionSlaveDeviceFailure  ExceptionCode = 0x04
+	ExceptionAcknowledge         ExceptionCode = 0x05
+	ExceptionSlaveDeviceBusy     ExceptionCode = 0x06
+	ExceptionMemoryParityError   ExceptionCode = 0x08
+	ExceptionGatewayPathFailed   ExceptionCode = 0x0A
+	ExceptionGatewayTargetFailed ExceptionCode = 0x0B
+	ExceptionPermissionDenied    ExceptionCode = 0x80 // Huawei specific, e.g. not logged in
+)
+
+var exceptionCodeDefinitions = map[ExceptionCode]string{
+	ExceptionIllegalFunction:     "illegal function",
+	ExceptionIllegalDataAddress:  "illegal data address",
+	ExceptionIllegalDataValue:    "illegal data value",
+	ExceptionSlaveDeviceFailure:  "slave device failure",
+	ExceptionAcknowledge:         "acknowledge",
+	ExceptionSlaveDeviceBusy:     "slave device busy",
+	ExceptionMemoryParityError:   "memory parity error",
+	ExceptionGatewayPathFailed:   "gateway path unavailable",
+	ExceptionGatewayTargetFailed: "gateway target device failed to respond",
+	ExceptionPermissionDenied:    "permission denied",
+}
+
+func (e ExceptionCode) String() string {
+	if s, ok := exceptionCodeDefinitions[e]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown exception 0x%02x", uint8(e))
+}
+
+// ModbusException is returned when the slave answers with an exception response,
+// i.e. the function code has the high bit set and the data is a single exception code
+type ModbusException struct {
+	FunctionCode  uint8
+	ExceptionCode ExceptionCode
+}
+
+func (e *ModbusException) Error() string {
+	return fmt.Sprintf("modbus exception for function 0x%02x: %v (0x%02x)", e.FunctionCode, e.ExceptionCode, uint8(e.ExceptionCode))
+}
+
+// IsException reports whether err is (or wraps) a ModbusException with the given code
+func IsException(err error, code ExceptionCode) bool {
+	var exc *ModbusException
+	if !errors.As(err, &exc) {
+		return false
+	}
+	return exc.ExceptionCode == code
+}
+
+func exceptionFromADU(adu *ModbusTCPADU) error {
+	if adu.FunctionCode&0x80 == 0 {
+		return nil
+	}
+
+	exc := &ModbusException{FunctionCode: adu.FunctionCode &^ 0x80}
+	if len(adu.Data) > 0 {
+		exc.ExceptionCode = ExceptionCode(adu.Data[0])
+	}
+	return exc
+}
@@ -0,0 +1,85 @@
+package modbus_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/mock"
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+)
+
+func TestExceptionDecoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(srv *mock.Server)
+		fc       uint8
+		data     []byte
+		wantCode modbus.ExceptionCode
+	}{
+		{
+			name:     "illegal data address",
+			fc:       0x03,
+			data:     []byte{0x01, 0xf4, 0x00, 0x01}, // 500, 1 register
+			wantCode: modbus.ExceptionIllegalDataAddress,
+		},
+		{
+			name:     "illegal function",
+			fc:       0x07,
+			wantCode: modbus.ExceptionIllegalFunction,
+		},
+		{
+			name:     "gateway target failed",
+			setup:    func(srv *mock.Server) { srv.UnitIDs = []uint8{2} },
+			fc:       0x03,
+			data:     []byte{0x00, 0x64, 0x00, 0x01},
+			wantCode: modbus.ExceptionGatewayTargetFailed,
+		},
+		{
+			name:     "huawei permission denied",
+			setup:    func(srv *mock.Server) { srv.RequireLogin = true },
+			fc:       0x03,
+			data:     []byte{0x00, 0x64, 0x00, 0x01},
+			wantCode: modbus.ExceptionPermissionDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := mock.NewServer()
+			setRegisters(t, srv, mock.Register{Addr: 100, Type: "u16", Value: "1"})
+			if tt.setup != nil {
+				tt.setup(srv)
+			}
+			mc := dialMock(t, srv)
+
+			_, err := mc.FunctionCall(context.Background(), tt.fc, tt.data)
+
+			var exc *modbus.ModbusException
+			if !errors.As(err, &exc) {
+				t.Fatalf("FunctionCall() error = %v, want a ModbusException", err)
+			}
+			if exc.FunctionCode != tt.fc || exc.ExceptionCode != tt.wantCode {
+				t.Errorf("got function 0x%02x code %v, want function 0x%02x code %v", exc.FunctionCode, exc.ExceptionCode, tt.fc, tt.wantCode)
+			}
+
+			wrapped := fmt.Errorf("reading: %w", err)
+			if !modbus.IsException(wrapped, tt.wantCode) {
+				t.Errorf("IsException(%v, %v) = false through a wrapped error", wrapped, tt.wantCode)
+			}
+			if modbus.IsException(wrapped, modbus.ExceptionSlaveDeviceBusy) {
+				t.Errorf("IsException(%v, busy) = true", wrapped)
+			}
+		})
+	}
+}
+
+func TestExceptionCodeString(t *testing.T) {
+	if got := modbus.ExceptionSlaveDeviceBusy.String(); got != "slave device busy" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := modbus.ExceptionCode(0x42).String(); got != "unknown exception 0x42" {
+		t.Errorf("String() = %q", got)
+	}
+}
@@ -0,0 +1,42 @@
+package modbus_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/mock"
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+)
+
+// dialMock serves srv on a random local port and returns a running connection to it as unit 1
+func dialMock(t *testing.T, srv *mock.Server) *modbus.ModbusConn {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go srv.Serve(ctx, ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial mock: %v", err)
+	}
+	mc := modbus.NewModbusConn(conn, 1)
+	go mc.Run(ctx)
+	t.Cleanup(func() { mc.Close() })
+	return mc
+}
+
+func setRegisters(t *testing.T, srv *mock.Server, regs ...mock.Register) {
+	t.Helper()
+
+	err := srv.SetRegisters(regs)
+	if err != nil {
+		t.Fatalf("set registers: %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+const (
+	maxReadRegisters = 125
+	defaultReadGap   = 16
+)
+
+// RegisterSpec describes one value to read, mirroring the modbus_* struct tags
+type RegisterSpec struct {
+	Name    string
+	Address uint16
+	// Numeric type name (i16, u32, float32, ...), or "string"
+	Type string
+	// The scalar the value was originally multiplied by, i.e. "230.1" stored as "2301" has a scalar of 10
+	Scalar float64
+	// Length in bytes, only for strings
+	StrLen uint16
+}
+
+func (s RegisterSpec) IsString() bool {
+	return s.Type == "string"
+}
+
+func (s RegisterSpec) IsBitfield() bool {
+	return strings.HasPrefix(s.Type, "bitfield")
+}
+
+// Registers is how many u16 registers the value occupies
+func (s RegisterSpec) Registers() uint16 {
+	if s.IsString() {
+		return (s.StrLen + 1) / 2
+	}
+	return uint16((sizeOfName(s.Type) + 1) / 2)
+}
+
+func (s RegisterSpec) Validate() error {
+	if s.IsString() {
+		if s.StrLen == 0 {
+			return fmt.Errorf("register %q is a string, but has no string length", s.Name)
+		}
+	} else if sizeOfName(s.Type) == 0 {
+		return fmt.Errorf("register %q has unknown type %q", s.Name, s.Type)
+	}
+
+	if s.Scalar == 0 {
+		return fmt.Errorf("register %q has a scalar of 0", s.Name)
+	}
+	if s.Registers() > maxReadRegisters {
+		return fmt.Errorf("register %q spans %d registers, which is more than %d", s.Name, s.Registers(), maxReadRegisters)
+	}
+	return nil
+}
+
+// ReadBlock is a single FC 0x03 read covering one or more specs
+type ReadBlock struct {
+	Address  uint16
+	Quantity uint16
+	// Indices into the specs slice that was planned
+	Specs []int
+}
+
+// PlanReads groups specs into as few reads as possible.
+// Specs are merged into one block if the space between them is at most maxGap registers,
+// and the block would still fit in a single read.
+func PlanReads(specs []RegisterSpec, maxGap uint16) []ReadBlock {
+	order := make([]int, len(specs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return specs[order[a]].Address < specs[order[b]].Address
+	})
+
+	blocks := []ReadBlock{}
+	var cur *ReadBlock
+	curEnd := 0
+
+	for _, i := range order {
+		spec := specs[i]
+		start := int(spec.Address)
+		end := start + int(spec.Registers())
+
+		if cur != nil && start <= curEnd+int(maxGap) && max(end, curEnd)-int(cur.Address) <= maxReadRegisters {
+			cur.Specs = append(cur.Specs, i)
+			curEnd = max(end, curEnd)
+			cur.Quantity = uint16(curEnd - int(cur.Address))
+			continue
+		}
+
+		blocks = append(blocks, ReadBlock{
+			Address:  spec.Address,
+			Quantity: spec.Registers(),
+			Specs:    []int{i},
+		})
+		cur = &blocks[len(blocks)-1]
+		curEnd = end
+	}
+
+	return blocks
+}
+
+// ReadRegisterSpecs reads every spec using as few calls as possible.
+// Results are in the same order as specs: a string for string specs, otherwise a pointer to the raw number (e.g. *int16).
+// Registers the slave reports as an illegal address are left as nil.
+func (c *ModbusConn) ReadRegisterSpecs(ctx context.Context, specs []RegisterSpec) ([]any, error) {
+	for _, spec := range specs {
+		if err := spec.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]any, len(specs))
+	blocks := PlanReads(specs, c.readGap)
+
+	slog.Debug("planned modbus reads", "registers", len(specs), "blocks", len(blocks))
+
+	for _, block := range blocks {
+		values, err := c.ReadHoldingRegistersU16(ctx, block.Address, block.Quantity)
+
+		if IsException(err, ExceptionIllegalDataAddress) && len(block.Specs) > 1 {
+			// One of the registers in the block (or the gap) isn't supported, so fall back to reading them one by one
+			slog.Debug("block read hit illegal address, reading individually", "address", block.Address, "quantity", block.Quantity)
+			for _, i := range block.Specs {
+				err := c.readSpecInto(ctx, specs[i], &results[i])
+				if err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		if IsException(err, ExceptionIllegalDataAddress) {
+			// register doesn't exist on this model, leave it empty rather than failing the whole query
+			slog.Debug("register not supported, skipping", "register", specs[block.Specs[0]].Name, "address", block.Address)
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read block at %d (%d registers): %w", block.Address, block.Quantity, err)
+		}
+
+		for _, i := range block.Specs {
+			offset := int(specs[i].Address-block.Address) * 2
+			results[i] = decodeSpec(specs[i], values[offset:])
+		}
+	}
+
+	return results, nil
+}
+
+func (c *ModbusConn) readSpecInto(ctx context.Context, spec RegisterSpec, result *any) error {
+	values, err := c.ReadHoldingRegistersU16(ctx, spec.Address, spec.Registers())
+	if IsException(err, ExceptionIllegalDataAddress) {
+		slog.Debug("register not supported, skipping", "register", spec.Name, "address", spec.Address)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %w", spec.Name, err)
+	}
+
+	*result = decodeSpec(spec, values)
+	return nil
+}
+
+func decodeSpec(spec RegisterSpec, b []byte) any {
+	if spec.IsString() {
+		return strings.TrimRight(string(b[:spec.StrLen]), "\x00")
+	}
+
+	result := anyNumByName(spec.Type)
+	binary.Decode(b, binary.BigEndian, result)
+	return result
+}
+
+// Decode decodes the spec's value from raw register data (i.e. from ReadHoldingRegistersU16), with the scalar applied like Scale
+func (s RegisterSpec) Decode(b []byte) (any, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	if len(b) < int(s.Registers())*2 {
+		return nil, fmt.Errorf("register %q needs %d bytes, but only got %d", s.Name, s.Registers()*2, len(b))
+	}
+	return s.Scale(decodeSpec(s, b)), nil
+}
+
+// Scale applies the spec's scalar to a raw value from ReadRegisterSpecs, giving a float64 (or the string/Bitfield as is)
+func (s RegisterSpec) Scale(raw any) any {
+	if str, ok := raw.(string); ok {
+		return str
+	}
+	if s.IsBitfield() {
+		return Bitfield(castAnyNumTo[uint32](raw))
+	}
+	return castAnyNumTo[float64](raw) / s.Scalar
+}
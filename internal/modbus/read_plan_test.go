@@ -0,0 +1,156 @@
+package modbus_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/mock"
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+)
+
+func u16Spec(addr uint16) modbus.RegisterSpec {
+	return modbus.RegisterSpec{Name: "reg", Address: addr, Type: "u16", Scalar: 1}
+}
+
+func TestPlanReads(t *testing.T) {
+	tests := []struct {
+		name   string
+		specs  []modbus.RegisterSpec
+		maxGap uint16
+		want   []modbus.ReadBlock
+	}{
+		{
+			name:  "contiguous",
+			specs: []modbus.RegisterSpec{u16Spec(100), u16Spec(101), {Name: "u32", Address: 102, Type: "u32", Scalar: 1}},
+			want:  []modbus.ReadBlock{{Address: 100, Quantity: 4, Specs: []int{0, 1, 2}}},
+		},
+		{
+			name:   "gap within max",
+			specs:  []modbus.RegisterSpec{u16Spec(100), u16Spec(110)},
+			maxGap: 16,
+			want:   []modbus.ReadBlock{{Address: 100, Quantity: 11, Specs: []int{0, 1}}},
+		},
+		{
+			name:  "gap beyond max",
+			specs: []modbus.RegisterSpec{u16Spec(100), u16Spec(110)},
+			want: []modbus.ReadBlock{
+				{Address: 100, Quantity: 1, Specs: []int{0}},
+				{Address: 110, Quantity: 1, Specs: []int{1}},
+			},
+		},
+		{
+			name:  "unsorted",
+			specs: []modbus.RegisterSpec{u16Spec(200), u16Spec(100)},
+			want: []modbus.ReadBlock{
+				{Address: 100, Quantity: 1, Specs: []int{1}},
+				{Address: 200, Quantity: 1, Specs: []int{0}},
+			},
+		},
+		{
+			name:   "split at the most registers per read",
+			specs:  []modbus.RegisterSpec{u16Spec(0), u16Spec(124), u16Spec(125)},
+			maxGap: 200,
+			want: []modbus.ReadBlock{
+				{Address: 0, Quantity: 125, Specs: []int{0, 1}},
+				{Address: 125, Quantity: 1, Specs: []int{2}},
+			},
+		},
+		{
+			name:  "inside a string",
+			specs: []modbus.RegisterSpec{{Name: "model", Address: 30000, Type: "string", StrLen: 30, Scalar: 1}, u16Spec(30005)},
+			want:  []modbus.ReadBlock{{Address: 30000, Quantity: 15, Specs: []int{0, 1}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := modbus.PlanReads(tt.specs, tt.maxGap)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlanReads() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadRegisterSpecs(t *testing.T) {
+	tests := []struct {
+		name        string
+		registers   []mock.Register
+		unsupported []uint16
+		specs       []modbus.RegisterSpec
+		// scaled values, nil where the register isn't supported
+		want []any
+	}{
+		{
+			name: "batched",
+			registers: []mock.Register{
+				{Addr: 100, Type: "u16", Value: "5"},
+				{Addr: 101, Type: "i16", Value: "-3"},
+				{Addr: 102, Type: "u32", Value: "70000"},
+				{Addr: 110, Type: "string", Len: 4, Value: "hi"},
+			},
+			specs: []modbus.RegisterSpec{
+				u16Spec(100),
+				{Name: "i16", Address: 101, Type: "i16", Scalar: 10},
+				{Name: "u32", Address: 102, Type: "u32", Scalar: 1},
+				{Name: "str", Address: 110, Type: "string", StrLen: 4, Scalar: 1},
+			},
+			want: []any{5.0, -0.3, 70000.0, "hi"},
+		},
+		{
+			name: "illegal address in a block falls back to single reads",
+			registers: []mock.Register{
+				{Addr: 100, Type: "u16", Value: "1"},
+				{Addr: 101, Type: "u16", Value: "2"},
+				{Addr: 102, Type: "u16", Value: "3"},
+			},
+			unsupported: []uint16{101},
+			specs:       []modbus.RegisterSpec{u16Spec(100), u16Spec(101), u16Spec(102)},
+			want:        []any{1.0, nil, 3.0},
+		},
+		{
+			name:      "unsupported on its own",
+			registers: []mock.Register{{Addr: 100, Type: "u16", Value: "1"}},
+			specs:     []modbus.RegisterSpec{u16Spec(100), u16Spec(500)},
+			want:      []any{1.0, nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := mock.NewServer()
+			setRegisters(t, srv, tt.registers...)
+			srv.SetUnsupported(tt.unsupported...)
+			mc := dialMock(t, srv)
+			mc.SetReadGap(0)
+
+			results, err := mc.ReadRegisterSpecs(context.Background(), tt.specs)
+			if err != nil {
+				t.Fatalf("ReadRegisterSpecs() error: %v", err)
+			}
+
+			got := make([]any, len(results))
+			for i, r := range results {
+				if r != nil {
+					got[i] = tt.specs[i].Scale(r)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadRegisterSpecs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadRegisterSpecsOtherException(t *testing.T) {
+	srv := mock.NewServer()
+	setRegisters(t, srv, mock.Register{Addr: 100, Type: "u16", Value: "1"})
+	srv.UnitIDs = []uint8{2}
+	mc := dialMock(t, srv)
+
+	_, err := mc.ReadRegisterSpecs(context.Background(), []modbus.RegisterSpec{u16Spec(100), u16Spec(101)})
+	if !modbus.IsException(err, modbus.ExceptionGatewayTargetFailed) {
+		t.Fatalf("ReadRegisterSpecs() error = %v, want a gateway target failed exception", err)
+	}
+}
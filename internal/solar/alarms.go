@@ -0,0 +1,101 @@
+package solar
+
+import (
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+)
+
+type Alarm struct {
+	ID       uint16 `json:"id"`
+	Name     string `json:"name"`
+	Severity string `json:"severity"`
+}
+
+const (
+	SeverityMajor   = "major"
+	SeverityMinor   = "minor"
+	SeverityWarning = "warning"
+)
+
+// ActiveAlarms decodes the Alarm 1/2/3 bitfield registers (32008-32010)
+func ActiveAlarms(alarm1, alarm2, alarm3 modbus.Bitfield) []Alarm {
+	alarms := []Alarm{}
+	for reg, bits := range []modbus.Bitfield{alarm1, alarm2, alarm3} {
+		for _, bit := range bits.SetBits() {
+			// the alarm registers are only 16 bits
+			if bit >= 16 {
+				continue
+			}
+			alarms = append(alarms, alarmDefinitions[reg][bit])
+		}
+	}
+	return alarms
+}
+
+// KnownAlarms lists every alarm in the table, in register and bit order
+func KnownAlarms() []Alarm {
+	alarms := []Alarm{}
+	for _, reg := range alarmDefinitions {
+		for _, alarm := range reg {
+			alarms = append(alarms, alarm)
+		}
+	}
+	return alarms
+}
+
+// Indexed by register (alarm 1, 2, 3) then bit, from the SUN2000 modbus interface definitions
+var alarmDefinitions = [3][16]Alarm{
+	{
+		{2001, "High string input voltage", SeverityMajor},
+		{2002, "DC arc fault", SeverityMajor},
+		{2011, "String reverse connection", SeverityMajor},
+		{2012, "String current backfeed", SeverityWarning},
+		{2013, "Abnormal string power", SeverityWarning},
+		{2021, "AFCI self-check fail", SeverityMajor},
+		{2031, "Phase wire short-circuited to PE", SeverityMajor},
+		{2032, "Grid loss", SeverityMajor},
+		{2033, "Grid undervoltage", SeverityMajor},
+		{2034, "Grid overvoltage", SeverityMajor},
+		{2035, "Grid voltage imbalance", SeverityMajor},
+		{2036, "Grid overfrequency", SeverityMajor},
+		{2037, "Grid underfrequency", SeverityMajor},
+		{2038, "Unstable grid frequency", SeverityMajor},
+		{2039, "Output overcurrent", SeverityMajor},
+		{2040, "Output DC component overhigh", SeverityMajor},
+	},
+	{
+		{2051, "Abnormal residual current", SeverityMajor},
+		{2061, "Abnormal grounding", SeverityMajor},
+		{2062, "Low insulation resistance", SeverityMajor},
+		{2063, "Overtemperature", SeverityMinor},
+		{2064, "Device fault", SeverityMajor},
+		{2065, "Upgrade failed or version mismatch", SeverityMinor},
+		{2066, "License expired", SeverityWarning},
+		{61440, "Faulty monitoring unit", SeverityMinor},
+		{2067, "Faulty power collector", SeverityMajor},
+		{2068, "Battery abnormal", SeverityMinor},
+		{2070, "Active islanding", SeverityMajor},
+		{2071, "Passive islanding", SeverityMajor},
+		{2072, "Transient AC overvoltage", SeverityMajor},
+		{2075, "Peripheral port short circuit", SeverityWarning},
+		{2077, "Churn output overload", SeverityMajor},
+		{2080, "Abnormal PV module configuration", SeverityMajor},
+	},
+	{
+		{2081, "Optimizer fault", SeverityWarning},
+		{2085, "Built-in PID operation abnormal", SeverityMinor},
+		{2014, "High input string voltage to ground", SeverityMajor},
+		{2086, "External fan abnormal", SeverityMajor},
+		{2069, "Battery reverse connection", SeverityMajor},
+		{2082, "On-grid/off-grid controller abnormal", SeverityMajor},
+		{2015, "PV string loss", SeverityWarning},
+		{2087, "Internal fan abnormal", SeverityMajor},
+		{2088, "DC protection unit abnormal", SeverityMajor},
+		{2089, "EL unit abnormal", SeverityMajor},
+		{2090, "Active adjustment instruction abnormal", SeverityMajor},
+		{2091, "Reactive adjustment instruction abnormal", SeverityMajor},
+		{2092, "CT wiring abnormal", SeverityMajor},
+		{2003, "DC arc fault (must be cleared manually)", SeverityMajor},
+		{2093, "DC switch abnormal", SeverityMinor},
+		{2094, "Battery allowable discharge capacity low", SeverityWarning},
+	},
+}
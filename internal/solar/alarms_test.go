@@ -0,0 +1,51 @@
+package solar
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+)
+
+func TestActiveAlarms(t *testing.T) {
+	tests := []struct {
+		name                   string
+		alarm1, alarm2, alarm3 modbus.Bitfield
+		wantIDs                []uint16
+	}{
+		{name: "none", wantIDs: []uint16{}},
+		{name: "one per register", alarm1: 1 << 7, alarm2: 1 << 3, alarm3: 1 << 15, wantIDs: []uint16{2032, 2063, 2094}},
+		{name: "register and bit order", alarm1: 1<<15 | 1, alarm3: 1 << 2, wantIDs: []uint16{2001, 2040, 2014}},
+		{name: "bits past 16 are ignored", alarm2: 1<<16 | 1<<31, wantIDs: []uint16{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alarms := ActiveAlarms(tt.alarm1, tt.alarm2, tt.alarm3)
+
+			// never nil, so it marshals as [] rather than null
+			if alarms == nil {
+				t.Fatalf("ActiveAlarms() = nil")
+			}
+			ids := []uint16{}
+			for _, a := range alarms {
+				ids = append(ids, a.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("ActiveAlarms() ids = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestKnownAlarms(t *testing.T) {
+	alarms := KnownAlarms()
+	if len(alarms) != 48 {
+		t.Errorf("KnownAlarms() has %d alarms, want 48", len(alarms))
+	}
+	for _, a := range alarms {
+		if a.ID == 0 || a.Name == "" || (a.Severity != SeverityMajor && a.Severity != SeverityMinor && a.Severity != SeverityWarning) {
+			t.Errorf("incomplete alarm %+v", a)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package solar
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+)
+
+// LUNA2000 battery telemetry, as seen through the inverter.
+// Power is positive while charging, and negative while discharging.
+type BatteryData struct {
+	SOCPercent        float64 `json:"soc_percent" modbus_type:"u16" modbus_scalar:"10" modbus_addr:"37760"`
+	RunningStatus     uint16  `json:"running_status" modbus_addr:"37762"`
+	RunningStatusText string  `json:"running_status_text"`
+	BusVoltageV       float64 `json:"bus_voltage_v" modbus_type:"u16" modbus_scalar:"10" modbus_addr:"37763"`
+	PowerW            float64 `json:"power_w" modbus_type:"i32" modbus_scalar:"1" modbus_addr:"37765"`
+
+	TotalChargeKWh    float64 `json:"total_charge_kwh" modbus_type:"u32" modbus_scalar:"100" modbus_addr:"37780"`
+	TotalDischargeKWh float64 `json:"total_discharge_kwh" modbus_type:"u32" modbus_scalar:"100" modbus_addr:"37782"`
+	DailyChargeKWh    float64 `json:"daily_charge_kwh" modbus_type:"u32" modbus_scalar:"100" modbus_addr:"37784"`
+	DailyDischargeKWh float64 `json:"daily_discharge_kwh" modbus_type:"u32" modbus_scalar:"100" modbus_addr:"37786"`
+
+	// Per unit (stack of packs), nil if that unit isn't connected
+	Unit1 *BatteryUnit `json:"unit_1"`
+	Unit2 *BatteryUnit `json:"unit_2"`
+}
+
+type BatteryUnit struct {
+	SOCPercent        float64 `json:"soc_percent"`
+	RunningStatus     uint16  `json:"running_status"`
+	RunningStatusText string  `json:"running_status_text"`
+	BusVoltageV       float64 `json:"bus_voltage_v"`
+	PowerW            float64 `json:"power_w"`
+
+	TotalChargeKWh    float64 `json:"total_charge_kwh"`
+	TotalDischargeKWh float64 `json:"total_discharge_kwh"`
+	DailyChargeKWh    float64 `json:"daily_charge_kwh"`
+	DailyDischargeKWh float64 `json:"daily_discharge_kwh"`
+}
+
+// The units have the same fields at different addresses, so they're read into these, then converted to BatteryUnit
+type batteryUnit1Registers struct {
+	SOCPercent        float64 `json:"soc_percent" modbus_type:"u16" modbus_scalar:"10" modbus_addr:"37004"`
+	RunningStatus     uint16  `json:"running_status" modbus_addr:"37000"`
+	RunningStatusText string  `json:"running_status_text"`
+	BusVoltageV       float64 `json:"bus_voltage_v" modbus_type:"u16" modbus_scalar:"10" modbus_addr:"37003"`
+	PowerW            float64 `json:"power_w" modbus_type:"i32" modbus_scalar:"1" modbus_addr:"37001"`
+
+	TotalChargeKWh    float64 `json:"total_charge_kwh" modbus_type:"u32" modbus_scalar:"100" modbus_addr:"37066"`
+	TotalDischargeKWh float64 `json:"total_discharge_kwh" modbus_type:"u32" modbus_scalar:"100" modbus_addr:"37068"`
+	DailyChargeKWh    float64 `json:"daily_charge_kwh" modbus_type:"u32" modbus_scalar:"100" modbus_addr:"37015"`
+	DailyDischargeKWh float64 `json:"daily_discharge_kwh" modbus_type:"u32" modbus_scalar:"100" modbus_addr:"37017"`
+}
+
+type batteryUnit2Registers struct {
+	SOCPercent        float64 `json:"soc_percent" modbus_type:"u16" modbus_scalar:"10" modbus_addr:"37738"`
+	RunningStatus     uint16  `json:"running_status" modbus_addr:"37741"`
+	RunningStatusText string  `json:"running_status_text"`
+	BusVoltageV       float64 `json:"bus_voltage_v" modbus_type:"u16" modbus_scalar:"10" modbus_addr:"37750"`
+	PowerW            float64 `json:"power_w" modbus_type:"i32" modbus_scalar:"1" modbus_addr:"37743"`
+
+	TotalChargeKWh    float64 `json:"total_charge_kwh" modbus_type:"u32" modbus_scalar:"100" modbus_addr:"37753"`
+	TotalDischargeKWh float64 `json:"total_discharge_kwh" modbus_type:"u32" modbus_scalar:"100" modbus_addr:"37755"`
+	DailyChargeKWh    float64 `json:"daily_charge_kwh" modbus_type:"u32" modbus_scalar:"100" modbus_addr:"37746"`
+	DailyDischargeKWh float64 `json:"daily_discharge_kwh" modbus_type:"u32" modbus_scalar:"100" modbus_addr:"37748"`
+}
+
+// Product model of each unit, 0 if there's nothing connected
+const (
+	regBatteryUnit1ProductModel = 47000
+	regBatteryUnit2ProductModel = 47089
+)
+
+// QueryBattery reads the battery telemetry, or returns nil if no battery units are connected
+func (c *Client) QueryBattery(ctx context.Context) (*BatteryData, error) {
+	results, err := c.conn.ReadRegisterSpecs(ctx, []modbus.RegisterSpec{
+		{Name: "battery_unit_1_product_model", Address: regBatteryUnit1ProductModel, Type: "u16", Scalar: 1},
+		{Name: "battery_unit_2_product_model", Address: regBatteryUnit2ProductModel, Type: "u16", Scalar: 1},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect battery: %w", err)
+	}
+
+	// inverters without battery support reject the register outright
+	present := func(i int) bool {
+		model, ok := results[i].(*uint16)
+		return ok && *model != 0
+	}
+	if !present(0) && !present(1) {
+		return nil, nil
+	}
+
+	b := &BatteryData{}
+	err = c.conn.QueryStructRegisters(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	b.RunningStatusText = BatteryStatusText(b.RunningStatus)
+
+	if present(0) {
+		var regs batteryUnit1Registers
+		err = c.conn.QueryStructRegisters(ctx, &regs)
+		if err != nil {
+			return nil, err
+		}
+		unit := BatteryUnit(regs)
+		unit.RunningStatusText = BatteryStatusText(unit.RunningStatus)
+		b.Unit1 = &unit
+	}
+
+	if present(1) {
+		var regs batteryUnit2Registers
+		err = c.conn.QueryStructRegisters(ctx, &regs)
+		if err != nil {
+			return nil, err
+		}
+		unit := BatteryUnit(regs)
+		unit.RunningStatusText = BatteryStatusText(unit.RunningStatus)
+		b.Unit2 = &unit
+	}
+
+	return b, nil
+}
+
+func BatteryStatusText(code uint16) string {
+	if s, ok := batteryStatusDefinitions[code]; ok {
+		return s
+	}
+	return "Unknown"
+}
+
+var batteryStatusDefinitions = map[uint16]string{
+	0: "Offline",
+	1: "Standby",
+	2: "Running",
+	3: "Fault",
+	4: "Sleep mode",
+}
@@ -0,0 +1,201 @@
+package solar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+)
+
+// Writable battery registers, from the SUN2000 modbus interface definitions
+const (
+	regBatteryMaxChargePower         = 47075 // u32, W, read only
+	regBatteryMaxDischargePower      = 47077 // u32, W, read only
+	regBatteryForcibleChargePeriod   = 47083 // u16, minutes
+	regBatteryWorkingMode            = 47086 // u16, BatteryWorkingMode
+	regBatteryChargeFromGrid         = 47087 // u16, 0/1
+	regBatteryForcibleChargeCommand  = 47100 // u16, forcibleCommand, write only
+	regBatteryForcibleSettingMode    = 47246 // u16, 0 for duration, 1 for target SOC
+	regBatteryForcibleChargePower    = 47247 // u32, W
+	regBatteryForcibleDischargePower = 47249 // u32, W
+)
+
+// ErrReadBackMismatch is returned when the inverter accepted a write, but reading the register back gives something else
+var ErrReadBackMismatch = errors.New("read back value does not match what was written")
+
+type BatteryWorkingMode uint16
+
+const (
+	BatteryModeAdaptive                BatteryWorkingMode = 0
+	BatteryModeFixedChargeDischarge    BatteryWorkingMode = 1
+	BatteryModeMaximiseSelfConsumption BatteryWorkingMode = 2
+	BatteryModeTimeOfUseLG             BatteryWorkingMode = 3
+	BatteryModeFullyFedToGrid          BatteryWorkingMode = 4
+	BatteryModeTimeOfUse               BatteryWorkingMode = 5
+)
+
+var batteryWorkingModeNames = map[BatteryWorkingMode]string{
+	BatteryModeAdaptive:                "adaptive",
+	BatteryModeFixedChargeDischarge:    "fixed_charge_discharge",
+	BatteryModeMaximiseSelfConsumption: "maximise_self_consumption",
+	BatteryModeTimeOfUseLG:             "time_of_use_lg",
+	BatteryModeFullyFedToGrid:          "fully_fed_to_grid",
+	BatteryModeTimeOfUse:               "time_of_use",
+}
+
+func (m BatteryWorkingMode) String() string {
+	if s, ok := batteryWorkingModeNames[m]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown (%d)", uint16(m))
+}
+
+// ParseBatteryWorkingMode is the inverse of String, i.e. "time_of_use"
+func ParseBatteryWorkingMode(s string) (BatteryWorkingMode, error) {
+	for mode, name := range batteryWorkingModeNames {
+		if strings.EqualFold(s, name) {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown battery working mode %q", s)
+}
+
+type forcibleCommand uint16
+
+const (
+	forcibleStop      forcibleCommand = 0
+	forcibleCharge    forcibleCommand = 1
+	forcibleDischarge forcibleCommand = 2
+)
+
+func (f forcibleCommand) String() string {
+	switch f {
+	case forcibleCharge:
+		return "charge"
+	case forcibleDischarge:
+		return "discharge"
+	}
+	return "stop"
+}
+
+// The inverter only accepts whole minutes, up to a day
+const maxForcibleDuration = 24 * time.Hour
+
+func (c *Client) BatteryWorkingMode(ctx context.Context) (BatteryWorkingMode, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	mode, err := modbus.ReadHoldingRegister[uint16](c.conn, ctx, regBatteryWorkingMode)
+	return BatteryWorkingMode(mode), err
+}
+
+func (c *Client) SetBatteryWorkingMode(ctx context.Context, mode BatteryWorkingMode) error {
+	if _, ok := batteryWorkingModeNames[mode]; !ok {
+		return fmt.Errorf("unknown battery working mode %d", uint16(mode))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	slog.Info("setting battery working mode", "mode", mode)
+	return writeVerified(c, ctx, regBatteryWorkingMode, uint16(mode))
+}
+
+// SetBatteryGridCharge allows or prevents the battery charging from the grid
+func (c *Client) SetBatteryGridCharge(ctx context.Context, enabled bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	value := uint16(0)
+	if enabled {
+		value = 1
+	}
+
+	slog.Info("setting battery grid charge", "enabled", enabled)
+	return writeVerified(c, ctx, regBatteryChargeFromGrid, value)
+}
+
+// ForceBatteryCharge charges the battery at powerW for the duration (rounded to whole minutes), regardless of the working mode
+func (c *Client) ForceBatteryCharge(ctx context.Context, powerW float64, duration time.Duration) error {
+	return c.forceBattery(ctx, forcibleCharge, powerW, duration)
+}
+
+// ForceBatteryDischarge is ForceBatteryCharge, but discharging
+func (c *Client) ForceBatteryDischarge(ctx context.Context, powerW float64, duration time.Duration) error {
+	return c.forceBattery(ctx, forcibleDischarge, powerW, duration)
+}
+
+// StopForcedBattery ends a forced charge/discharge early, handing control back to the working mode
+func (c *Client) StopForcedBattery(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	slog.Info("stopping forced battery charge/discharge")
+	return c.conn.WriteSingleRegister(ctx, regBatteryForcibleChargeCommand, uint16(forcibleStop))
+}
+
+func (c *Client) forceBattery(ctx context.Context, cmd forcibleCommand, powerW float64, duration time.Duration) error {
+	minutes := math.Round(duration.Minutes())
+	if minutes < 1 || duration > maxForcibleDuration {
+		return fmt.Errorf("duration %v must be between 1 minute and %v", duration, maxForcibleDuration)
+	}
+	if !isFinite(powerW) || powerW <= 0 || powerW > math.MaxUint32 {
+		return fmt.Errorf("power %vW must be more than 0", powerW)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	powerReg, maxReg := uint16(regBatteryForcibleChargePower), uint16(regBatteryMaxChargePower)
+	if cmd == forcibleDischarge {
+		powerReg, maxReg = regBatteryForcibleDischargePower, regBatteryMaxDischargePower
+	}
+
+	maxPower, err := modbus.ReadHoldingRegister[uint32](c.conn, ctx, maxReg)
+	if err != nil {
+		return fmt.Errorf("failed to read battery max power: %w", err)
+	}
+	// 0 means the battery hasn't reported it, so leave it to the inverter to reject
+	if maxPower != 0 && powerW > float64(maxPower) {
+		return fmt.Errorf("power %vW is more than the battery's maximum of %dW", powerW, maxPower)
+	}
+
+	slog.Info("forcing battery charge/discharge", "command", cmd, "power_w", powerW, "minutes", minutes)
+
+	err = writeVerified(c, ctx, regBatteryForcibleSettingMode, uint16(0))
+	if err != nil {
+		return err
+	}
+	err = writeVerified(c, ctx, powerReg, uint32(math.Round(powerW)))
+	if err != nil {
+		return err
+	}
+	err = writeVerified(c, ctx, regBatteryForcibleChargePeriod, uint16(minutes))
+	if err != nil {
+		return err
+	}
+	// the command register is write only, so there's nothing to read back (the echo is still checked)
+	return c.conn.WriteSingleRegister(ctx, regBatteryForcibleChargeCommand, uint16(cmd))
+}
+
+// writeVerified writes a register, then reads it back to make sure the inverter actually took the value
+func writeVerified[T uint16 | int16 | uint32 | int32](c *Client, ctx context.Context, address uint16, value T) error {
+	err := modbus.WriteHoldingRegister(c.conn, ctx, address, value)
+	if err != nil {
+		return fmt.Errorf("failed to write register %d: %w", address, err)
+	}
+
+	got, err := modbus.ReadHoldingRegister[T](c.conn, ctx, address)
+	if err != nil {
+		return fmt.Errorf("failed to read back register %d: %w", address, err)
+	}
+	if got != value {
+		return fmt.Errorf("register %d: wrote %v, read %v: %w", address, value, got, ErrReadBackMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package solar
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestForceBattery(t *testing.T) {
+	c, srv := dialDefaultMock(t)
+	ctx := context.Background()
+
+	err := c.ForceBatteryCharge(ctx, 2500, 90*time.Minute)
+	if err != nil {
+		t.Fatalf("ForceBatteryCharge() error: %v", err)
+	}
+	if got := srv.Registers(47083, 1)[0]; got != 90 {
+		t.Errorf("forcible period = %d, want 90", got)
+	}
+	if got := srv.Registers(47247, 2); got[0] != 0 || got[1] != 2500 {
+		t.Errorf("forcible charge power = %v, want 2500", got)
+	}
+	if got := srv.Registers(47100, 1)[0]; got != uint16(forcibleCharge) {
+		t.Errorf("forcible command = %d, want %d", got, forcibleCharge)
+	}
+
+	err = c.StopForcedBattery(ctx)
+	if err != nil {
+		t.Fatalf("StopForcedBattery() error: %v", err)
+	}
+	if got := srv.Registers(47100, 1)[0]; got != uint16(forcibleStop) {
+		t.Errorf("forcible command = %d, want %d", got, forcibleStop)
+	}
+}
+
+func TestForceBatteryInvalid(t *testing.T) {
+	c, _ := dialDefaultMock(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		powerW   float64
+		duration time.Duration
+	}{
+		{name: "no power", powerW: 0, duration: time.Hour},
+		{name: "NaN power", powerW: math.NaN(), duration: time.Hour},
+		{name: "more than the battery's max", powerW: 6000, duration: time.Hour},
+		{name: "too short", powerW: 1000, duration: 10 * time.Second},
+		{name: "too long", powerW: 1000, duration: 25 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.ForceBatteryDischarge(ctx, tt.powerW, tt.duration)
+			if err == nil {
+				t.Errorf("ForceBatteryDischarge(%v, %v) should fail", tt.powerW, tt.duration)
+			}
+		})
+	}
+}
@@ -17,18 +17,9 @@ func BroadcastHello(dst net.IP, self net.IP) error {
 	laddr := &net.UDPAddr{IP: net.IPv4zero, Port: 0}
 	raddr := &net.UDPAddr{IP: dst, Port: 6600}
 
-	self4 := self.To4()
-	if self4 == nil {
-		return fmt.Errorf("couldn't convert self IP %v to ipv4", self)
-	}
-
-	packet := []byte{
-		'Z', 'Z', 'Z', 'Z',
-		0, 65, 58, 4,
-		self4[0],
-		self4[1],
-		self4[2],
-		self4[3],
+	packet, err := helloPacket(self)
+	if err != nil {
+		return err
 	}
 
 	conn, err := net.DialUDP("udp", laddr, raddr)
@@ -54,3 +45,19 @@ func BroadcastHello(dst net.IP, self net.IP) error {
 	slog.Info("received broadcast hello response", "size", n, "response", resp)
 	return nil
 }
+
+func helloPacket(self net.IP) ([]byte, error) {
+	self4 := self.To4()
+	if self4 == nil {
+		return nil, fmt.Errorf("couldn't convert self IP %v to ipv4", self)
+	}
+
+	return []byte{
+		'Z', 'Z', 'Z', 'Z',
+		0, 65, 58, 4,
+		self4[0],
+		self4[1],
+		self4[2],
+		self4[3],
+	}, nil
+}
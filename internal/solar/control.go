@@ -0,0 +1,296 @@
+package solar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+)
+
+// Writable control registers, from the SUN2000 modbus interface definitions
+const (
+	regRatedPower                    = 30073 // u32, W, read only
+	regDeviceStatus                  = 32089 // u16, read only
+	regActivePowerPercentageDerating = 40125 // i16, scalar 10, %
+	regActivePowerFixedDerating      = 40126 // u32, W
+	regStartup                       = 40200 // u16, write only
+	regShutdown                      = 40201 // u16, write only
+	regActivePowerControlMode        = 47415 // u16, ExportLimitMode
+	regMaxFeedGridPowerW             = 47416 // i32, W
+	regMaxFeedGridPowerPercent       = 47418 // i16, scalar 10, %
+)
+
+// ExportLimitMode is the inverter's "active power control mode", limiting what's fed to the grid as measured by the meter
+type ExportLimitMode uint16
+
+const (
+	ExportUnlimited      ExportLimitMode = 0
+	ExportDIScheduling   ExportLimitMode = 1
+	ExportZero           ExportLimitMode = 5
+	ExportLimitedW       ExportLimitMode = 6
+	ExportLimitedPercent ExportLimitMode = 7
+)
+
+var exportLimitModeNames = map[ExportLimitMode]string{
+	ExportUnlimited:      "unlimited",
+	ExportDIScheduling:   "di_scheduling",
+	ExportZero:           "zero_export",
+	ExportLimitedW:       "limited_w",
+	ExportLimitedPercent: "limited_percent",
+}
+
+func (m ExportLimitMode) String() string {
+	if s, ok := exportLimitModeNames[m]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown (%d)", uint16(m))
+}
+
+func (m ExportLimitMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// ParseExportLimitMode is the inverse of String, i.e. "limited_w"
+func ParseExportLimitMode(s string) (ExportLimitMode, error) {
+	for mode, name := range exportLimitModeNames {
+		if strings.EqualFold(s, name) {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown export limit mode %q", s)
+}
+
+// PowerLimits is what's currently applied, as read back from the inverter
+type PowerLimits struct {
+	RatedPowerW float64 `json:"rated_power_w" modbus_type:"u32" modbus_scalar:"1" modbus_addr:"30073"`
+
+	ActivePowerPercent float64 `json:"active_power_percent" modbus_type:"i16" modbus_scalar:"10" modbus_addr:"40125"`
+	ActivePowerW       float64 `json:"active_power_w" modbus_type:"u32" modbus_scalar:"1" modbus_addr:"40126"`
+
+	ExportMode    ExportLimitMode `json:"export_mode" modbus_type:"u16" modbus_addr:"47415"`
+	ExportW       float64         `json:"export_w" modbus_type:"i32" modbus_scalar:"1" modbus_addr:"47416"`
+	ExportPercent float64         `json:"export_percent" modbus_type:"i16" modbus_scalar:"10" modbus_addr:"47418"`
+}
+
+const (
+	StatusShutdownCommand = 0x0301
+
+	// how often the device status is polled while waiting for it to change
+	statusPollInterval = 5 * time.Second
+)
+
+// ErrStatusTimeout is returned when the inverter didn't reach the expected device status in time
+var ErrStatusTimeout = errors.New("timed out waiting for device status")
+
+// IsOnGrid is true for all of the grid connected statuses, including when power limited
+func IsOnGrid(status uint16) bool {
+	return status>>8 == 0x02
+}
+
+func (c *Client) DeviceStatus(ctx context.Context) (uint16, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	return modbus.ReadHoldingRegister[uint16](c.conn, ctx, regDeviceStatus)
+}
+
+// WaitForDeviceStatus polls the device status until match is true for it, or returns ErrStatusTimeout with the last status seen
+func (c *Client) WaitForDeviceStatus(ctx context.Context, timeout time.Duration, match func(status uint16) bool) (uint16, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(statusPollInterval)
+	defer ticker.Stop()
+
+	var status uint16
+	for {
+		var err error
+		status, err = c.DeviceStatus(ctx)
+		if err == nil && match(status) {
+			return status, nil
+		}
+		if err != nil && ctx.Err() == nil {
+			// the inverter is sometimes briefly unresponsive while changing state
+			slog.Debug("failed to read device status, will retry", "err", err)
+		} else if err == nil {
+			slog.Debug("waiting for device status", "status", StatusText(status))
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return status, fmt.Errorf("%w after %v, last status was %q", ErrStatusTimeout, timeout, StatusText(status))
+			}
+			return status, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// PowerOnAndConfirm sends the power on command, then waits for the inverter to connect to the grid.
+// Start up includes grid checks, so this can take a few minutes, and never succeeds without sun.
+func (c *Client) PowerOnAndConfirm(ctx context.Context, timeout time.Duration) error {
+	err := c.PowerOn(ctx)
+	if err != nil {
+		return err
+	}
+	return c.ConfirmPoweredOn(ctx, timeout)
+}
+
+// PowerOffAndConfirm sends the power off command, then waits for the inverter to report it's shut down by command
+func (c *Client) PowerOffAndConfirm(ctx context.Context, timeout time.Duration) error {
+	err := c.PowerOff(ctx)
+	if err != nil {
+		return err
+	}
+	return c.ConfirmPoweredOff(ctx, timeout)
+}
+
+// ConfirmPoweredOn waits for the inverter to connect to the grid after PowerOn
+func (c *Client) ConfirmPoweredOn(ctx context.Context, timeout time.Duration) error {
+	status, err := c.WaitForDeviceStatus(ctx, timeout, IsOnGrid)
+	if err != nil {
+		return err
+	}
+	slog.Info("inverter is back on grid", "status", StatusText(status))
+	return nil
+}
+
+// ConfirmPoweredOff waits for the inverter to report it's shut down by command after PowerOff
+func (c *Client) ConfirmPoweredOff(ctx context.Context, timeout time.Duration) error {
+	status, err := c.WaitForDeviceStatus(ctx, timeout, func(status uint16) bool {
+		return status == StatusShutdownCommand
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("inverter is shut down", "status", StatusText(status))
+	return nil
+}
+
+func (c *Client) PowerOn(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	slog.Info("sending power on command")
+	return c.conn.WriteSingleRegister(ctx, regStartup, 0)
+}
+
+func (c *Client) PowerOff(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	slog.Info("sending power off command")
+	return c.conn.WriteSingleRegister(ctx, regShutdown, 0)
+}
+
+// SetActivePowerPercentage derates the inverter's output to a percentage of its rated power
+func (c *Client) SetActivePowerPercentage(ctx context.Context, percent float64) error {
+	if !isFinite(percent) || percent < 0 || percent > 100 {
+		return fmt.Errorf("active power percentage %v must be between 0 and 100", percent)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	slog.Info("setting active power percentage derating", "percent", percent)
+	return writeVerified(c, ctx, regActivePowerPercentageDerating, int16(math.Round(percent*10)))
+}
+
+// isFinite is checked before any range check, since NaN compares false against everything
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// RatedPower is the model's rated (nominal) output, which absolute limits are checked against
+func (c *Client) RatedPower(ctx context.Context) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	rated, err := modbus.ReadHoldingRegister[uint32](c.conn, ctx, regRatedPower)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read rated power: %w", err)
+	}
+	if rated == 0 {
+		return 0, fmt.Errorf("inverter reported a rated power of 0")
+	}
+	return float64(rated), nil
+}
+
+// SetActivePowerLimit derates the inverter's output to a fixed number of watts, between 0 and the rated power
+func (c *Client) SetActivePowerLimit(ctx context.Context, watts float64) error {
+	rated, err := c.RatedPower(ctx)
+	if err != nil {
+		return err
+	}
+	if !isFinite(watts) || watts < 0 || watts > rated {
+		return fmt.Errorf("active power limit %vW must be between 0 and the rated power of %vW", watts, rated)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	slog.Info("setting active power fixed derating", "watts", watts)
+	return writeVerified(c, ctx, regActivePowerFixedDerating, uint32(math.Round(watts)))
+}
+
+// SetExportLimit sets how much can be fed to the grid. The value is watts for ExportLimitedW, a percentage of the rated power
+// for ExportLimitedPercent, and ignored otherwise.
+func (c *Client) SetExportLimit(ctx context.Context, mode ExportLimitMode, value float64) error {
+	if _, ok := exportLimitModeNames[mode]; !ok {
+		return fmt.Errorf("unknown export limit mode %d", uint16(mode))
+	}
+
+	switch mode {
+	case ExportLimitedW:
+		rated, err := c.RatedPower(ctx)
+		if err != nil {
+			return err
+		}
+		if !isFinite(value) || value < 0 || value > rated {
+			return fmt.Errorf("export limit %vW must be between 0 and the rated power of %vW", value, rated)
+		}
+	case ExportLimitedPercent:
+		if !isFinite(value) || value < 0 || value > 100 {
+			return fmt.Errorf("export limit %v%% must be between 0 and 100", value)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	slog.Info("setting export limit", "mode", mode, "value", value)
+
+	// set the limit before switching mode, so it never applies a stale value
+	switch mode {
+	case ExportLimitedW:
+		err := writeVerified(c, ctx, regMaxFeedGridPowerW, int32(math.Round(value)))
+		if err != nil {
+			return err
+		}
+	case ExportLimitedPercent:
+		err := writeVerified(c, ctx, regMaxFeedGridPowerPercent, int16(math.Round(value*10)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeVerified(c, ctx, regActivePowerControlMode, uint16(mode))
+}
+
+func (c *Client) PowerLimits(ctx context.Context) (*PowerLimits, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	l := &PowerLimits{}
+	err := c.conn.QueryStructRegisters(ctx, l)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,123 @@
+package solar
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	// No site this is used for generates or draws anywhere near 1MW, so anything faster is a bad read
+	defaultMaxCounterRateKW = 1000
+	// After this many rejected reads in a row, the new value is taken as real, i.e. the counter was reset
+	counterResyncReads = 3
+)
+
+// CounterGuard filters spurious reads out of energy counters (every "_kwh" field), which otherwise show up as huge
+// spikes in long term statistics. Counters only go up, except "daily" counters which may go back down on a new day,
+// and never faster than MaxRateKW. A rejected read is replaced with the last good value.
+// Until there's a baseline to check against, i.e. two reads in a row that agree, the counter is left out of the sample.
+type CounterGuard struct {
+	MaxRateKW float64
+
+	last map[string]counterState
+}
+
+type counterState struct {
+	value    float64
+	at       time.Time
+	rejected int
+	// false until a second read agrees with value, so a bad first read can't become the baseline
+	confirmed bool
+	// daily counters only, set once a new day has started until the counter goes back down
+	resetPending bool
+}
+
+func NewCounterGuard() *CounterGuard {
+	return &CounterGuard{
+		MaxRateKW: defaultMaxCounterRateKW,
+		last:      make(map[string]counterState),
+	}
+}
+
+func isCounter(key string) bool {
+	return strings.HasSuffix(key, "_kwh")
+}
+
+func isDailyCounter(key string) bool {
+	return strings.Contains(key, "daily")
+}
+
+// Apply checks every counter in the sample against the last good read, fixing up bad ones in place,
+// and removing ones without a baseline yet
+func (g *CounterGuard) Apply(s *Sample) {
+	fields := s.Fields[:0]
+	for _, field := range s.Fields {
+		value, ok := field.Number()
+		if !isCounter(field.Key) || !ok {
+			fields = append(fields, field)
+			continue
+		}
+
+		prev, seen := g.last[field.Key]
+		if !seen {
+			g.last[field.Key] = counterState{value: value, at: s.Timestamp}
+			continue
+		}
+
+		// the inverter's clock (or time zone) may not match ours, so the reset is allowed any time after midnight
+		if isDailyCounter(field.Key) && !sameLocalDay(prev.at, s.Timestamp) {
+			prev.resetPending = true
+		}
+
+		if g.plausible(prev, value, s.Timestamp) {
+			resetPending := prev.resetPending && value >= prev.value
+			g.last[field.Key] = counterState{value: value, at: s.Timestamp, resetPending: resetPending, confirmed: true}
+			fields = append(fields, field)
+			continue
+		}
+
+		if !prev.confirmed {
+			// the two reads disagree, and there's no telling which is right, so wait for one that agrees with this one
+			slog.Warn("energy counter reads disagree, waiting for a baseline", "field", field.Key, "previous", prev.value, "value", value)
+			g.last[field.Key] = counterState{value: value, at: s.Timestamp}
+			continue
+		}
+
+		prev.rejected++
+		if prev.rejected >= counterResyncReads {
+			slog.Warn("energy counter has been implausible for several reads, starting again from it", "field", field.Key, "previous", prev.value, "value", value)
+			g.last[field.Key] = counterState{value: value, at: s.Timestamp}
+			continue
+		}
+
+		slog.Warn("ignoring implausible energy counter read", "field", field.Key, "previous", prev.value, "value", value)
+		g.last[field.Key] = prev
+		field.Value = prev.value
+		fields = append(fields, field)
+	}
+	s.Fields = fields
+}
+
+func (g *CounterGuard) plausible(prev counterState, value float64, at time.Time) bool {
+	if value < 0 {
+		return false
+	}
+
+	base := prev.value
+	if prev.resetPending && value < base {
+		// reset at midnight, so it's counting from 0 again
+		base = 0
+	}
+	if value < base {
+		return false
+	}
+
+	elapsed := at.Sub(prev.at).Hours()
+	return value-base <= g.MaxRateKW*elapsed
+}
+
+func sameLocalDay(a, b time.Time) bool {
+	a, b = a.Local(), b.Local()
+	return a.YearDay() == b.YearDay() && a.Year() == b.Year()
+}
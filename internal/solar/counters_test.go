@@ -0,0 +1,109 @@
+package solar
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCounterGuard(t *testing.T) {
+	start := time.Date(2026, 6, 1, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name  string
+		key   string
+		reads []float64
+		// published value per read, nil where the counter is left out
+		want []any
+	}{
+		{
+			name:  "needs a baseline",
+			key:   "accumulated_yield_kwh",
+			reads: []float64{100, 100.1, 100.2},
+			want:  []any{nil, 100.1, 100.2},
+		},
+		{
+			name:  "bad first read",
+			key:   "accumulated_yield_kwh",
+			reads: []float64{65535, 100, 100.1},
+			want:  []any{nil, nil, 100.1},
+		},
+		{
+			name:  "backwards",
+			key:   "accumulated_yield_kwh",
+			reads: []float64{100, 100.1, 50, 100.2},
+			want:  []any{nil, 100.1, 100.1, 100.2},
+		},
+		{
+			name:  "spike",
+			key:   "accumulated_yield_kwh",
+			reads: []float64{100, 100.1, 5000, 100.2},
+			want:  []any{nil, 100.1, 100.1, 100.2},
+		},
+		{
+			name:  "real reset",
+			key:   "meter_exported_kwh",
+			reads: []float64{100, 100.1, 1, 1, 1, 1.1},
+			want:  []any{nil, 100.1, 100.1, 100.1, nil, 1.1},
+		},
+		{
+			name:  "not a counter",
+			key:   "active_power_w",
+			reads: []float64{100, 5, 5000},
+			want:  []any{100.0, 5.0, 5000.0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewCounterGuard()
+			for i, read := range tt.reads {
+				s := &Sample{
+					Timestamp: start.Add(time.Duration(i) * time.Minute),
+					Fields:    []Field{{Key: "other", Value: 1.0}, {Key: tt.key, Value: read}},
+				}
+				g.Apply(s)
+
+				f, ok := s.Get(tt.key)
+				var got any
+				if ok {
+					got = f.Value
+				}
+				if got != tt.want[i] {
+					t.Errorf("read %d (%v) published as %v, want %v", i, read, got, tt.want[i])
+				}
+				if _, ok := s.Get("other"); !ok {
+					t.Errorf("read %d dropped a field that isn't a counter", i)
+				}
+			}
+		})
+	}
+}
+
+func TestCounterGuardDailyReset(t *testing.T) {
+	g := NewCounterGuard()
+	evening := time.Date(2026, 6, 1, 23, 58, 0, 0, time.Local)
+
+	for i, read := range []struct {
+		at    time.Time
+		value float64
+		want  float64
+	}{
+		{evening, 20, 0},
+		{evening.Add(time.Minute), 20, 20},
+		{evening.Add(3 * time.Minute), 0, 0},
+		{evening.Add(4 * time.Minute), 0.01, 0.01},
+	} {
+		s := &Sample{Timestamp: read.at, Fields: []Field{{Key: "daily_yield_kwh", Value: read.value}}}
+		g.Apply(s)
+
+		if i == 0 {
+			if len(s.Fields) != 0 {
+				t.Errorf("first read published without a baseline")
+			}
+			continue
+		}
+		if f, _ := s.Get("daily_yield_kwh"); f.Value != read.want {
+			t.Errorf("read %d published as %v, want %v", i, f.Value, read.want)
+		}
+	}
+}
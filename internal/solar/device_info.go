@@ -0,0 +1,175 @@
+package solar
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeviceInfo is a device description from FC 0x2B/0x0E, one per device behind the connection (i.e. the inverter itself,
+// and anything attached to it)
+type DeviceInfo struct {
+	Model           string `json:"model"`
+	SoftwareVersion string `json:"software_version"`
+	ProtocolVersion string `json:"protocol_version"`
+	ESN             string `json:"esn"`
+	// Assigned by the inverter, 0 is the device the modbus card is in
+	DeviceID       int    `json:"device_id"`
+	FeatureVersion string `json:"feature_version"`
+	DeviceType     string `json:"device_type"`
+
+	// Every key=value pair, including those above and any that aren't documented
+	Props map[string]string `json:"props"`
+}
+
+// Object IDs in the read device identification response
+const (
+	deviceInfoObjectCount       = 0x87
+	deviceInfoObjectDescription = 0x88
+
+	// guard against a device that keeps saying more follows
+	maxDeviceInfoPages = 16
+)
+
+type deviceInfoObject struct {
+	id   uint8
+	data []byte
+}
+
+func (c *Client) QueryDeviceInfos(ctx context.Context) ([]DeviceInfo, error) { // yes that's what it's called
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	objs := []deviceInfoObject{}
+	objID := uint8(deviceInfoObjectCount)
+
+	for page := 0; ; page++ {
+		if page >= maxDeviceInfoPages {
+			return nil, fmt.Errorf("device info still had more to follow after %d pages", maxDeviceInfoPages)
+		}
+
+		pageObjs, more, nextObjID, err := c.queryDeviceInfoPage(ctx, objID)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, pageObjs...)
+
+		if !more {
+			break
+		}
+		if nextObjID <= objID {
+			return nil, fmt.Errorf("device info paging went backwards, from object %#x to %#x", objID, nextObjID)
+		}
+		objID = nextObjID
+	}
+
+	infos := []DeviceInfo{}
+	expected := -1
+	for _, obj := range objs {
+		switch obj.id {
+		case deviceInfoObjectCount:
+			if len(obj.data) > 0 {
+				expected = int(obj.data[0])
+			}
+		case deviceInfoObjectDescription:
+			infos = append(infos, parseDeviceInfo(string(obj.data)))
+		default:
+			slog.Debug("ignoring unknown device info object", "id", obj.id, "data", fmt.Sprintf("%v", obj.data))
+		}
+	}
+
+	if expected >= 0 && expected != len(infos) {
+		slog.Warn("inverter reported a different number of devices than it described", "expected", expected, "described", len(infos))
+	}
+	return infos, nil
+}
+
+func (c *Client) queryDeviceInfoPage(ctx context.Context, objID uint8) (objs []deviceInfoObject, more bool, nextObjID uint8, err error) {
+	resp, err := c.conn.FunctionCall(ctx, 0x2B, []byte{
+		0x0e,
+		0x03,
+		objID,
+	})
+	if err != nil {
+		return nil, false, 0, fmt.Errorf("failed to query device infos: %w", err)
+	}
+
+	slog.Debug("query device infos response", "response", resp)
+	if len(resp.Data) < 6 {
+		return nil, false, 0, fmt.Errorf("expected at least 6 bytes in device info response, got %d", len(resp.Data))
+	}
+
+	more = resp.Data[3] == 0xFF
+	nextObjID = resp.Data[4]
+	numObjects := int(resp.Data[5])
+
+	cursor := resp.Data[6:]
+	for len(cursor) > 0 {
+		if len(cursor) < 2 {
+			return nil, false, 0, fmt.Errorf("device info object truncated")
+		}
+		id, length := cursor[0], int(cursor[1])
+		if len(cursor)-2 < length {
+			return nil, false, 0, fmt.Errorf("device info object %#x reported length %d, but only %d bytes are left", id, length, len(cursor)-2)
+		}
+
+		objs = append(objs, deviceInfoObject{id: id, data: cursor[2 : 2+length]})
+		cursor = cursor[2+length:]
+	}
+
+	if len(objs) != numObjects {
+		slog.Warn("device info response had a different number of objects than it reported", "reported", numObjects, "found", len(objs))
+	}
+	return objs, more, nextObjID, nil
+}
+
+// parseDeviceInfo parses a description like "1=SUN2000-10KTL-M1;2=V100R001C00SPC141;3=V2.0;4=ESN;5=0;6=127106;8=410"
+func parseDeviceInfo(desc string) DeviceInfo {
+	info := DeviceInfo{Props: make(map[string]string)}
+
+	for _, prop := range strings.Split(desc, ";") {
+		k, v, ok := strings.Cut(prop, "=")
+		if !ok {
+			continue
+		}
+		info.Props[k] = v
+
+		/*
+		   from interface defs file:
+
+		   1. Device Model
+		   2. Device software version
+		   3. Interface protocol version
+		   4. ESN
+		   5. Device ID (assigned by NEs; 0 indicates the master device into which the modbus card is isnserted)
+		   6. Feature Version
+		   7. (unlisted)
+		   8. Device Type
+		*/
+		switch k {
+		case "1":
+			info.Model = v
+		case "2":
+			info.SoftwareVersion = v
+		case "3":
+			info.ProtocolVersion = v
+		case "4":
+			info.ESN = v
+		case "5":
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				slog.Warn("device info has a non-numeric device id", "device_id", v)
+			}
+			info.DeviceID = id
+		case "6":
+			info.FeatureVersion = v
+		case "8":
+			info.DeviceType = v
+		}
+	}
+
+	return info
+}
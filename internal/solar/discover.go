@@ -0,0 +1,189 @@
+package solar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+)
+
+// HelloPort is where inverters listen for the hello broadcast
+const HelloPort = 6600
+
+// DiscoveryTarget is where to send a hello, and the address of ours on that network for the inverter to reply to
+type DiscoveryTarget struct {
+	Dst  net.IP
+	Self net.IP
+	// defaults to HelloPort
+	Port int
+}
+
+// HelloReply is an inverter answering the hello broadcast
+type HelloReply struct {
+	IP  net.IP `json:"ip"`
+	Raw []byte `json:"raw"`
+	// The reply format isn't documented, but if it has a device description in it (like device info), it's parsed
+	Info *DeviceInfo `json:"info,omitempty"`
+}
+
+// Discover sends a hello to each target, and collects every reply that comes back within wait.
+// Replies are de-duplicated by IP, as an inverter can hear the same hello more than once.
+func Discover(ctx context.Context, targets []DiscoveryTarget, wait time.Duration) ([]HelloReply, error) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	var mu sync.Mutex
+	replies := []HelloReply{}
+	seen := make(map[string]bool)
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(targets))
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = discoverTarget(ctx, target, func(reply HelloReply) {
+				mu.Lock()
+				defer mu.Unlock()
+				if seen[reply.IP.String()] {
+					return
+				}
+				seen[reply.IP.String()] = true
+				replies = append(replies, reply)
+			})
+		}()
+	}
+	wg.Wait()
+
+	// only fail if nothing could be sent at all, a missing interface shouldn't hide the others
+	err := errors.Join(errs...)
+	if err != nil && len(replies) == 0 {
+		return nil, err
+	}
+	if err != nil {
+		slog.Warn("some discovery broadcasts failed", "err", err)
+	}
+	return replies, nil
+}
+
+func discoverTarget(ctx context.Context, target DiscoveryTarget, onReply func(HelloReply)) error {
+	packet, err := helloPacket(target.Self)
+	if err != nil {
+		return err
+	}
+	port := target.Port
+	if port == 0 {
+		port = HelloPort
+	}
+
+	// not "connected" to the destination like BroadcastHello, as replies come from the inverter's IP, not the broadcast address
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: target.Self})
+	if err != nil {
+		return fmt.Errorf("failed to listen on %v: %v", target.Self, err)
+	}
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	_, err = conn.WriteToUDP(packet, &net.UDPAddr{IP: target.Dst, Port: port})
+	if err != nil {
+		return fmt.Errorf("failed to send hello to %v: %v", target.Dst, err)
+	}
+	slog.Debug("sent discovery hello", "dst", target.Dst, "self", target.Self)
+
+	buf := make([]byte, 8192)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read hello replies on %v: %v", target.Self, err)
+		}
+
+		raw := bytes.Clone(buf[:n])
+		if !bytes.HasPrefix(raw, []byte("ZZZZ")) {
+			slog.Debug("ignoring non-hello reply", "from", from, "size", n)
+			continue
+		}
+
+		reply := HelloReply{IP: from.IP, Raw: raw}
+		if desc, ok := helloDescription(raw); ok {
+			info := parseDeviceInfo(desc)
+			reply.Info = &info
+		}
+		onReply(reply)
+	}
+}
+
+// helloDescription finds a "1=...;2=..." style description after the 8 byte header, if there is one
+func helloDescription(raw []byte) (string, bool) {
+	if len(raw) <= 8 {
+		return "", false
+	}
+	desc := bytes.TrimRight(raw[8:], "\x00")
+	for _, b := range desc {
+		if b < 0x20 || b > 0x7e {
+			return "", false
+		}
+	}
+	if !bytes.Contains(desc, []byte("=")) {
+		return "", false
+	}
+	return string(desc), true
+}
+
+// PortProbe is the result of checking whether a port answers Modbus TCP
+type PortProbe struct {
+	Port int `json:"port"`
+	// Accepted a TCP connection
+	Open bool `json:"open"`
+	// Answered a register read, even if it was an exception (i.e. not logged in)
+	Modbus bool   `json:"modbus"`
+	Model  string `json:"model,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ProbeModbusPort connects to the port and tries to read the model name.
+// Inverters usually need a hello from us first, so do that (or Discover) before probing.
+func ProbeModbusPort(ctx context.Context, ip net.IP, port int, unitID uint8, timeout time.Duration) PortProbe {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	probe := PortProbe{Port: port}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), fmt.Sprint(port)))
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	probe.Open = true
+
+	mc := modbus.NewModbusConn(conn, unitID)
+	defer mc.Close()
+	go mc.Run(ctx)
+
+	model, err := modbus.ReadHoldingRegisterString(mc, ctx, 30000, 30)
+	var exc *modbus.ModbusException
+	switch {
+	case err == nil:
+		probe.Modbus = true
+		probe.Model = model
+	case errors.As(err, &exc):
+		probe.Modbus = true
+		probe.Error = exc.Error()
+	default:
+		probe.Error = err.Error()
+	}
+	return probe
+}
@@ -3,7 +3,9 @@ package solar
 import (
 	"context"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
@@ -11,6 +13,49 @@ import (
 	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
 )
 
+var (
+	ErrLoginUnknownUser     = errors.New("unknown user")
+	ErrLoginWrongPassword   = errors.New("wrong password")
+	ErrLoginLocked          = errors.New("account locked, from too many failed logins")
+	ErrLoginAlreadyLoggedIn = errors.New("user already logged in")
+
+	// The inverter accepted the login, but couldn't prove it knows the password too,
+	// so it's likely something else on the network pretending to be the inverter
+	ErrLoginInverterNotVerified = errors.New("inverter couldn't prove it knows the password")
+)
+
+// Failure codes in the login result. Huawei don't document them, so these are best guesses from watching logins fail,
+// hisolar sometimes says "user already logged in" too. Unknown codes still fail, just without a typed error.
+var loginFailureCodes = map[uint8]error{
+	2:  ErrLoginUnknownUser,
+	5:  ErrLoginAlreadyLoggedIn,
+	6:  ErrLoginWrongPassword,
+	38: ErrLoginLocked,
+}
+
+// LoginError is the inverter rejecting a login. Err is one of the ErrLogin* errors, or nil for an unknown code.
+type LoginError struct {
+	Code uint8
+	Err  error
+}
+
+func (e *LoginError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("login failed with unknown code %d", e.Code)
+	}
+	return fmt.Sprintf("login failed: %v (code %d)", e.Err, e.Code)
+}
+
+func (e *LoginError) Unwrap() error {
+	return e.Err
+}
+
+// IsLoginCredentialsError is for a login that won't work by just trying again, so shouldn't be retried
+// (especially as retrying a wrong password gets the account locked)
+func IsLoginCredentialsError(err error) bool {
+	return errors.Is(err, ErrLoginUnknownUser) || errors.Is(err, ErrLoginWrongPassword) || errors.Is(err, ErrLoginLocked)
+}
+
 func loginHash(password string, challenge []byte) []byte {
 	k := sha256.Sum256([]byte(password))
 	mac := hmac.New(sha256.New, k[:])
@@ -18,6 +63,14 @@ func loginHash(password string, challenge []byte) []byte {
 	return mac.Sum(nil)
 }
 
+// newLoginClientChallenge is a fresh challenge for the inverter to prove itself with, every login,
+// so an old response from the real inverter can't be replayed
+func newLoginClientChallenge() []byte {
+	challenge := make([]byte, 16)
+	rand.Read(challenge)
+	return challenge
+}
+
 func (c *Client) loginInit(ctx context.Context) (*modbus.ModbusTCPADU, error) {
 	slog.Debug("sending login init")
 	resp, err := c.conn.FunctionCall(ctx, 0x41, []byte{
@@ -27,7 +80,7 @@ func (c *Client) loginInit(ctx context.Context) (*modbus.ModbusTCPADU, error) {
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("err doing PDU for login init: %v", err)
+		return nil, fmt.Errorf("err doing PDU for login init: %w", err)
 	}
 
 	slog.Debug("login init response", "response", resp)
@@ -35,18 +88,13 @@ func (c *Client) loginInit(ctx context.Context) (*modbus.ModbusTCPADU, error) {
 	return resp, nil
 }
 
-func (c *Client) loginInitialChallengeResponse(ctx context.Context, username string, challResp []byte) (*modbus.ModbusTCPADU, error) {
+func (c *Client) loginInitialChallengeResponse(ctx context.Context, username string, challResp []byte, clientChallenge []byte) (*modbus.ModbusTCPADU, error) {
 	partTwoReqData := []byte{
 		0x25, // login subcmd 2
 
-		byte(16 + 1 + len(username) + 1 + len(challResp)),
-
-		// placeholder client challenge
-		41, 42, 43, 44,
-		45, 46, 47, 48,
-		41, 42, 43, 44,
-		45, 46, 47, 48,
+		byte(len(clientChallenge) + 1 + len(username) + 1 + len(challResp)),
 	}
+	partTwoReqData = append(partTwoReqData, clientChallenge...)
 
 	partTwoReqData = append(partTwoReqData, byte(len(username)))
 	partTwoReqData = append(partTwoReqData, []byte(username)...)
@@ -57,7 +105,7 @@ func (c *Client) loginInitialChallengeResponse(ctx context.Context, username str
 	slog.Debug("sending login challenge part two", "data", fmt.Sprintf("%v", partTwoReqData))
 	partTwoResp, err := c.conn.FunctionCall(ctx, 0x41, partTwoReqData)
 	if err != nil {
-		return nil, fmt.Errorf("error on part 2 of login(data=%v): %v", partTwoResp, err)
+		return nil, fmt.Errorf("error on part 2 of login(data=%v): %w", partTwoResp, err)
 	}
 	slog.Debug("response to login challenge part two", "response", partTwoResp)
 
@@ -84,19 +132,53 @@ func (c *Client) Login(ctx context.Context, username string, password string) er
 	slog.Debug("responding to first challenge", "challenge", firstChallenge, "response", challResponse)
 	time.Sleep(time.Second)
 
-	partTwoResp, err := c.loginInitialChallengeResponse(ctx, username, challResponse)
+	clientChallenge := newLoginClientChallenge()
+	partTwoResp, err := c.loginInitialChallengeResponse(ctx, username, challResponse, clientChallenge)
 	if err != nil {
 		return err
 	}
 
 	slog.Debug("login part two response", "data", fmt.Sprintf("%v", partTwoResp.Data))
 
-	// response is.... 37, 36, 1, 32, ...... , <code>, 55
-	// codes
-	// 6: incorrect password...?
-	// 38: incorrect password? or maybe account locked?
-	// 2: invalid username?
-	// hisolar sometimes says "user already logged in", so maybe that's one of those error codes?
+	status, inverterMAC, err := parseLoginResult(partTwoResp.Data)
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		return &LoginError{Code: status, Err: loginFailureCodes[status]}
+	}
 
-	return nil
+	// the inverter proves it knows the password too, by hashing the challenge we sent it
+	var verifyErr error
+	switch {
+	case len(inverterMAC) == 0:
+		verifyErr = fmt.Errorf("%w, it didn't respond to our challenge", ErrLoginInverterNotVerified)
+	case !hmac.Equal(inverterMAC, loginHash(password, clientChallenge)):
+		verifyErr = fmt.Errorf("%w, its response to our challenge didn't match", ErrLoginInverterNotVerified)
+	}
+	if verifyErr != nil && c.skipInverterVerify {
+		slog.Warn("logged in, but couldn't verify the inverter (skip_inverter_verify is set)", "err", verifyErr)
+		return nil
+	}
+	return verifyErr
+}
+
+// parseLoginResult splits up the response to login part two.
+//
+// response is.... 37, 36, 1, 32, ...... , <code>, 55
+// i.e. subcmd, length, 1 (idk), mac length, the inverter's 32 byte response to our challenge, the result code, 55 (idk)
+// codes
+// 6: incorrect password...?
+// 38: incorrect password? or maybe account locked?
+// 2: invalid username?
+// hisolar sometimes says "user already logged in", so maybe that's one of those error codes?
+func parseLoginResult(data []byte) (status uint8, mac []byte, err error) {
+	if len(data) < 4 || data[0] != 0x25 {
+		return 0, nil, fmt.Errorf("invalid login result %v", data)
+	}
+	macLen := int(data[3])
+	if len(data) < 4+macLen+1 {
+		return 0, nil, fmt.Errorf("login result is too short for a %d byte mac and the result code: %v", macLen, data)
+	}
+	return data[4+macLen], data[4 : 4+macLen], nil
 }
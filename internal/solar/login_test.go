@@ -0,0 +1,111 @@
+package solar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/mock"
+)
+
+func TestParseLoginResult(t *testing.T) {
+	mac := bytes.Repeat([]byte{0xab}, 32)
+	result := func(code byte) []byte {
+		// as captured from a real SUN2000: 37, 36, 1, 32, ...32 byte mac..., <code>, 55
+		data := append([]byte{37, 36, 1, 32}, mac...)
+		return append(data, code, 55)
+	}
+
+	tests := []struct {
+		name       string
+		data       []byte
+		wantStatus uint8
+		wantMAC    []byte
+		wantErr    bool
+	}{
+		{name: "ok", data: result(0), wantMAC: mac},
+		{name: "wrong password", data: result(6), wantStatus: 6, wantMAC: mac},
+		{name: "no mac", data: []byte{37, 4, 1, 0, 0, 55}, wantMAC: []byte{}},
+		{name: "truncated mac", data: append([]byte{37, 36, 1, 32}, mac[:10]...), wantErr: true},
+		{name: "missing code", data: append([]byte{37, 36, 1, 32}, mac...), wantErr: true},
+		{name: "too short", data: []byte{37, 36, 1}, wantErr: true},
+		{name: "wrong subcommand", data: append([]byte{36}, result(0)[1:]...), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, mac, err := parseLoginResult(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLoginResult() error = %v, want error %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus || !bytes.Equal(mac, tt.wantMAC) {
+				t.Errorf("parseLoginResult() = %d, %x, want %d, %x", status, mac, tt.wantStatus, tt.wantMAC)
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name       string
+		username   string
+		password   string
+		spoofMAC   bool
+		skipVerify bool
+		wantErr    error
+		wantCode   uint8
+	}{
+		{name: "success", username: "user", password: "z"},
+		{name: "wrong password", username: "user", password: "nope", wantErr: ErrLoginWrongPassword, wantCode: 6},
+		{name: "unknown user", username: "nobody", password: "z", wantErr: ErrLoginUnknownUser, wantCode: 2},
+		{name: "unverified inverter", username: "user", password: "z", spoofMAC: true, wantErr: ErrLoginInverterNotVerified},
+		{name: "unverified inverter, skipping verify", username: "user", password: "z", spoofMAC: true, skipVerify: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := mock.NewServer()
+			srv.Username, srv.Password = "user", "z"
+			srv.RequireLogin = true
+			srv.SpoofLoginMAC = tt.spoofMAC
+			err := srv.SetRegisters(mock.DefaultRegisters())
+			if err != nil {
+				t.Fatalf("set registers: %v", err)
+			}
+			c := dialMock(t, srv)
+			c.SetSkipInverterVerify(tt.skipVerify)
+			ctx := context.Background()
+
+			err = c.Login(ctx, tt.username, tt.password)
+
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Login() error: %v", err)
+				}
+				// only a logged in session can read
+				_, err = c.DeviceStatus(ctx)
+				if err != nil {
+					t.Errorf("reading after login: %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Login() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantCode == 0 {
+				return
+			}
+			var loginErr *LoginError
+			if !errors.As(err, &loginErr) || loginErr.Code != tt.wantCode {
+				t.Errorf("Login() error = %#v, want a LoginError with code %d", err, tt.wantCode)
+			}
+			if !IsLoginCredentialsError(err) {
+				t.Errorf("IsLoginCredentialsError(%v) = false", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package solar
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/mock"
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+)
+
+// dialMock serves srv on a random local port and returns a running client for unit 1
+func dialMock(t *testing.T, srv *mock.Server) *Client {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go srv.Serve(ctx, ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial mock: %v", err)
+	}
+	c := NewClient(modbus.NewModbusConn(conn, 1))
+	go c.Run(ctx)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// dialDefaultMock is dialMock for a mock serving the default registers, already logged in
+func dialDefaultMock(t *testing.T) (*Client, *mock.Server) {
+	t.Helper()
+
+	srv := mock.NewServer()
+	err := srv.SetRegisters(mock.DefaultRegisters())
+	if err != nil {
+		t.Fatalf("set registers: %v", err)
+	}
+	return dialMock(t, srv), srv
+}
@@ -3,7 +3,10 @@ package solar
 import (
 	"context"
 	"fmt"
+	"slices"
 	"time"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
 )
 
 // Inverter telemetry that I care about
@@ -16,6 +19,12 @@ type Data struct {
 	DeviceStatus        uint16  `json:"device_status" modbus_addr:"32089"`
 	DeviceStatusText    string  `json:"device_status_text"`
 
+	// Raw alarm bitfields, decoded into Alarms
+	Alarm1 modbus.Bitfield `json:"alarm_1" modbus_type:"bitfield16" modbus_addr:"32008"`
+	Alarm2 modbus.Bitfield `json:"alarm_2" modbus_type:"bitfield16" modbus_addr:"32009"`
+	Alarm3 modbus.Bitfield `json:"alarm_3" modbus_type:"bitfield16" modbus_addr:"32010"`
+	Alarms []Alarm         `json:"alarms"`
+
 	// I believe this is DC input power?
 	InputPowerW float64 `json:"input_power_w" modbus_type:"i32" modbus_scalar:"1" modbus_addr:"32064"`
 	// ...whereas this is the inverted AC power
@@ -26,6 +35,10 @@ type Data struct {
 	GridVoltageV    float64 `json:"grid_voltage_v" modbus_type:"u16" modbus_scalar:"10" modbus_addr:"32066"`
 	GridFrequencyHz float64 `json:"grid_frequency_hz" modbus_type:"u16" modbus_scalar:"100" modbus_addr:"32085"`
 
+	// Energy yield (kWh), daily resets at midnight
+	AccumulatedYieldKWh float64 `json:"accumulated_yield_kwh" modbus_type:"u32" modbus_scalar:"100" modbus_addr:"32106"`
+	DailyYieldKWh       float64 `json:"daily_yield_kwh" modbus_type:"u32" modbus_scalar:"100" modbus_addr:"32114"`
+
 	// MPPT cumulative energy (kWh)
 	// yes, funny word, but its consistent with others
 	MPPT1CumKWh float64 `json:"mppt1_cum_kwh" modbus_type:"u32" modbus_scalar:"100" modbus_addr:"32212"`
@@ -46,6 +59,10 @@ type Data struct {
 	MeterGridCVoltageV float64 `json:"meter_grid_c_voltage_v" modbus_type:"i32" modbus_scalar:"10" modbus_addr:"37105"`
 	MeterGridFrequency float64 `json:"meter_grid_frequency_hz" modbus_type:"i16" modbus_scalar:"100" modbus_addr:"37118"`
 
+	// Energy counted by the external meter (kWh), exported is what's been fed in to the grid
+	MeterExportedKWh float64 `json:"meter_exported_kwh" modbus_type:"i32" modbus_scalar:"100" modbus_addr:"37119"`
+	MeterImportedKWh float64 `json:"meter_imported_kwh" modbus_type:"i32" modbus_scalar:"100" modbus_addr:"37121"`
+
 	// Power read by the external meter
 	MeterActivePowerW     float64 `json:"meter_active_power_w" modbus_type:"i32" modbus_scalar:"1" modbus_addr:"37113"`
 	MeterReactivePowerW   float64 `json:"meter_reactive_power_w" modbus_type:"i32" modbus_scalar:"1" modbus_addr:"37115"`
@@ -54,6 +71,9 @@ type Data struct {
 	// Power read within the inverter
 	InverterActivePowerW   float64 `json:"inverter_active_power_w" modbus_type:"i32" modbus_scalar:"1" modbus_addr:"32080"`
 	InverterReactivePowerW float64 `json:"inverter_reactive_power_w" modbus_type:"i32" modbus_scalar:"1" modbus_addr:"32082"`
+
+	// nil if there's no battery
+	Battery *BatteryData `json:"battery"`
 }
 
 func (c *Client) Query(ctx context.Context) (*Data, error) {
@@ -67,6 +87,13 @@ func (c *Client) Query(ctx context.Context) (*Data, error) {
 	}
 
 	d.DeviceStatusText = StatusText(d.DeviceStatus)
+	d.Alarms = ActiveAlarms(d.Alarm1, d.Alarm2, d.Alarm3)
+
+	d.Battery, err = c.QueryBattery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	return d, nil
 }
 
@@ -81,6 +108,21 @@ func StatusText(code uint16) string {
 	return "Unknown"
 }
 
+// StatusTexts lists every known status text, ordered by code
+func StatusTexts() []string {
+	codes := make([]uint16, 0, len(deviceStatusDefinitions))
+	for code := range deviceStatusDefinitions {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+
+	texts := make([]string, len(codes))
+	for i, code := range codes {
+		texts[i] = deviceStatusDefinitions[code]
+	}
+	return texts
+}
+
 var deviceStatusDefinitions = map[uint16]string{
 	0x0000: "Standby, initializing",
 	0x0001: "Standby, detecting insulation resistance",
@@ -0,0 +1,114 @@
+package solar
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+	"gopkg.in/yaml.v3"
+)
+
+// RegisterDef is one entry in a register map file. The fields mean the same as the modbus_* tags on Data.
+type RegisterDef struct {
+	// Key in the published sample
+	Name   string  `yaml:"name"`
+	Addr   uint16  `yaml:"addr"`
+	Type   string  `yaml:"type"`
+	Scalar float64 `yaml:"scalar"`
+	StrLen uint16  `yaml:"str_len"`
+}
+
+// RegisterMap replaces the registers hard-coded in Data, for models with a different map
+type RegisterMap []RegisterDef
+
+func LoadRegisterMap(path string) (RegisterMap, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m RegisterMap
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for i := range m {
+		def := &m[i]
+		if def.Name == "" {
+			return nil, fmt.Errorf("register %d at %d has no name", i, def.Addr)
+		}
+		if def.Name == "timestamp" || seen[def.Name] {
+			return nil, fmt.Errorf("register name %q is reserved or used more than once", def.Name)
+		}
+		seen[def.Name] = true
+
+		if def.Scalar == 0 {
+			def.Scalar = 1
+		}
+		if err := def.spec().Validate(); err != nil {
+			return nil, err
+		}
+		// device_status is decoded to text and used to confirm power on/off, so it has to be a number
+		if def.Name == "device_status" && def.spec().IsString() {
+			return nil, fmt.Errorf("device_status at %d must be a number, not a string", def.Addr)
+		}
+	}
+
+	return m, nil
+}
+
+func (def RegisterDef) spec() modbus.RegisterSpec {
+	return modbus.RegisterSpec{
+		Name:    def.Name,
+		Address: def.Addr,
+		Type:    def.Type,
+		Scalar:  def.Scalar,
+		StrLen:  def.StrLen,
+	}
+}
+
+// QueryRegisterMap is Query, but for a register map instead of Data.
+// Numbers are scaled to floats, except bitfields. If the map has a device_status, device_status_text is added after it,
+// and if it has alarm_1, alarm_2 and alarm_3, the decoded alarms are added at the end.
+func (c *Client) QueryRegisterMap(ctx context.Context, m RegisterMap) (*Sample, error) {
+	s := &Sample{Timestamp: time.Now().UTC()}
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	specs := make([]modbus.RegisterSpec, len(m))
+	for i, def := range m {
+		specs[i] = def.spec()
+	}
+
+	results, err := c.conn.ReadRegisterSpecs(ctx, specs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, spec := range specs {
+		// register doesn't exist on this model
+		if results[i] == nil {
+			continue
+		}
+
+		value := spec.Scale(results[i])
+		s.Fields = append(s.Fields, Field{Key: spec.Name, Value: value})
+
+		if status, ok := s.DeviceStatus(); ok && spec.Name == "device_status" {
+			s.Fields = append(s.Fields, Field{Key: "device_status_text", Value: StatusText(status)})
+		}
+	}
+
+	// only decode alarms if the map has all three registers, a partial set would under report
+	alarm1, ok1 := s.bitfield("alarm_1")
+	alarm2, ok2 := s.bitfield("alarm_2")
+	alarm3, ok3 := s.bitfield("alarm_3")
+	if ok1 && ok2 && ok3 {
+		s.Fields = append(s.Fields, Field{Key: "alarms", Value: ActiveAlarms(alarm1, alarm2, alarm3)})
+	}
+
+	return s, nil
+}
@@ -0,0 +1,80 @@
+package solar
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/mock"
+)
+
+func writeRegisterMap(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "map.yaml")
+	err := os.WriteFile(path, []byte(contents), 0o600)
+	if err != nil {
+		t.Fatalf("write register map: %v", err)
+	}
+	return path
+}
+
+func TestLoadRegisterMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr bool
+	}{
+		{name: "valid", yaml: "- {name: pv_power, addr: 32064, type: i32}\n- {name: device_status, addr: 32089, type: u16}\n"},
+		{name: "no name", yaml: "- {addr: 32064, type: i32}\n", wantErr: true},
+		{name: "duplicate", yaml: "- {name: a, addr: 1, type: u16}\n- {name: a, addr: 2, type: u16}\n", wantErr: true},
+		{name: "reserved", yaml: "- {name: timestamp, addr: 1, type: u16}\n", wantErr: true},
+		{name: "bad type", yaml: "- {name: a, addr: 1, type: u17}\n", wantErr: true},
+		{name: "string device status", yaml: "- {name: device_status, addr: 32089, type: string, str_len: 2}\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadRegisterMap(writeRegisterMap(t, tt.yaml))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadRegisterMap() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQueryRegisterMapDeviceStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		typ  string
+	}{
+		{name: "u16", typ: "u16"},
+		{name: "bitfield16", typ: "bitfield16"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := mock.NewServer()
+			err := srv.SetRegisters([]mock.Register{{Addr: 32089, Type: "u16", Value: "512"}})
+			if err != nil {
+				t.Fatalf("set registers: %v", err)
+			}
+			c := dialMock(t, srv)
+
+			m, err := LoadRegisterMap(writeRegisterMap(t, "- {name: device_status, addr: 32089, type: "+tt.typ+"}\n"))
+			if err != nil {
+				t.Fatalf("LoadRegisterMap() error: %v", err)
+			}
+			s, err := c.QueryRegisterMap(context.Background(), m)
+			if err != nil {
+				t.Fatalf("QueryRegisterMap() error: %v", err)
+			}
+
+			f, ok := s.Get("device_status_text")
+			if !ok || f.Value != StatusText(512) {
+				t.Errorf("device_status_text = %v, want %q", f.Value, StatusText(512))
+			}
+		})
+	}
+}
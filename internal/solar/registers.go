@@ -0,0 +1,23 @@
+package solar
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+)
+
+// ReadRegisters reads raw register data, for poking at registers that aren't in Data or a register map
+func (c *Client) ReadRegisters(ctx context.Context, address, quantity uint16) ([]byte, error) {
+	return c.conn.ReadHoldingRegistersU16(ctx, address, quantity)
+}
+
+// WriteRegisters writes raw register data, using FC 0x06 for a single register, otherwise FC 0x10
+func (c *Client) WriteRegisters(ctx context.Context, address uint16, values []byte) error {
+	if len(values) == 2 {
+		return c.conn.WriteSingleRegister(ctx, address, binary.BigEndian.Uint16(values))
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("nothing to write")
+	}
+	return c.conn.WriteMultipleRegisters(ctx, address, values)
+}
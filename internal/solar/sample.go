@@ -0,0 +1,175 @@
+package solar
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+)
+
+// Sample is one set of readings, flattened to ordered key/values.
+// It comes either from Data, or from a register map loaded at runtime, and is what gets published.
+type Sample struct {
+	Timestamp time.Time
+	// Name of the device the sample is from, only set when there's more than one on the connection
+	Device string
+	Fields []Field
+}
+
+type Field struct {
+	Key   string
+	Value any
+}
+
+func (f Field) IsString() bool {
+	_, ok := f.Value.(string)
+	return ok
+}
+
+// Number returns the value as a float, or false if it isn't numeric
+func (f Field) Number() (float64, bool) {
+	v := reflect.ValueOf(f.Value)
+	switch {
+	case v.CanFloat():
+		return v.Float(), true
+	case v.CanInt():
+		return float64(v.Int()), true
+	case v.CanUint():
+		return float64(v.Uint()), true
+	}
+	return 0, false
+}
+
+func (s *Sample) Get(key string) (Field, bool) {
+	for _, f := range s.Fields {
+		if f.Key == key {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+func (s *Sample) getString(key string) string {
+	f, _ := s.Get(key)
+	str, _ := f.Value.(string)
+	return str
+}
+
+func (s *Sample) ModelName() string {
+	return s.getString("model_name")
+}
+
+func (s *Sample) SerialNumber() string {
+	return s.getString("serial_number")
+}
+
+// DeviceStatus returns the raw status code, or false if the sample doesn't have one
+func (s *Sample) DeviceStatus() (uint16, bool) {
+	f, ok := s.Get("device_status")
+	if !ok {
+		return 0, false
+	}
+	n, ok := f.Number()
+	return uint16(n), ok
+}
+
+func (s *Sample) bitfield(key string) (modbus.Bitfield, bool) {
+	f, ok := s.Get(key)
+	if !ok {
+		return 0, false
+	}
+	n, ok := f.Number()
+	return modbus.Bitfield(n), ok
+}
+
+// Alarms returns the decoded active alarms, or false if the sample doesn't have them
+func (s *Sample) Alarms() ([]Alarm, bool) {
+	f, ok := s.Get("alarms")
+	if !ok {
+		return nil, false
+	}
+	alarms, ok := f.Value.([]Alarm)
+	return alarms, ok
+}
+
+// MarshalJSON produces a flat object, timestamp (and device) first then fields in order, the same as marshalling Data
+func (s *Sample) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"timestamp":`)
+
+	b, err := json.Marshal(s.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(b)
+
+	if s.Device != "" {
+		b, err := json.Marshal(s.Device)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(`,"device":`)
+		buf.Write(b)
+	}
+
+	for _, f := range s.Fields {
+		key, err := json.Marshal(f.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, fmt.Errorf("marshal %q: %v", f.Key, err)
+		}
+
+		buf.WriteByte(',')
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (s *Sample) Pretty() string {
+	parts := make([]string, len(s.Fields))
+	for i, f := range s.Fields {
+		parts[i] = fmt.Sprintf("%s=%v", f.Key, f.Value)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Sample flattens Data by its json tags.
+// Nested struct pointers (i.e. Battery) are flattened with their key as a prefix, so battery.unit_1.soc_percent
+// becomes battery_unit_1_soc_percent, and are left out entirely when nil.
+func (d *Data) Sample() *Sample {
+	s := &Sample{Timestamp: d.Timestamp}
+	s.flatten("", reflect.ValueOf(*d))
+	return s
+}
+
+func (s *Sample) flatten(prefix string, v reflect.Value) {
+	st := v.Type()
+	for i := 0; i < st.NumField(); i++ {
+		key, _, _ := strings.Cut(st.Field(i).Tag.Get("json"), ",")
+		if key == "" || key == "-" || key == "timestamp" {
+			continue
+		}
+		key = prefix + key
+
+		field := v.Field(i)
+		if field.Kind() == reflect.Pointer && field.Type().Elem().Kind() == reflect.Struct {
+			if !field.IsNil() {
+				s.flatten(key+"_", field.Elem())
+			}
+			continue
+		}
+
+		s.Fields = append(s.Fields, Field{Key: key, Value: field.Interface()})
+	}
+}
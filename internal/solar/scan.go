@@ -0,0 +1,128 @@
+package solar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+)
+
+const (
+	defaultScanBlock = 64
+	maxScanBlock     = 125
+
+	// how many times a read is retried while the inverter (or dongle) says it's busy, before giving up on the scan
+	scanBusyRetries = 5
+)
+
+// scanBusy is an exception that says nothing about the registers, just that the read should be tried again later
+func scanBusy(err error) bool {
+	return modbus.IsException(err, modbus.ExceptionSlaveDeviceBusy) ||
+		modbus.IsException(err, modbus.ExceptionAcknowledge) ||
+		modbus.IsException(err, modbus.ExceptionGatewayPathFailed) ||
+		modbus.IsException(err, modbus.ExceptionGatewayTargetFailed)
+}
+
+// ScanOptions controls how hard ScanRegisters leans on the inverter
+type ScanOptions struct {
+	// Largest number of registers to read at once, defaults to 64. Blocks shrink around unsupported registers,
+	// and grow back once reads succeed again.
+	MaxBlock uint16
+	// Minimum time between reads, so the inverter isn't overwhelmed
+	Interval time.Duration
+	// How long to wait for each read, defaults to 10s
+	Timeout time.Duration
+	// Called after each read, with how many registers have been scanned so far
+	Progress func(done, total int)
+}
+
+// ScannedRegister is one register from ScanRegisters
+type ScannedRegister struct {
+	Address uint16
+	// The raw register, nil unless it responded
+	Value []byte
+	// The exception the inverter answered with if it didn't respond, which is always an illegal data address
+	Exception *modbus.ModbusException
+}
+
+// ScanRegisters reads every register from..to (inclusive), to find which exist on an undocumented model.
+// Blocks that raise an illegal data address exception are split in half until the registers causing it are found.
+// Reads the inverter is too busy for are retried with a backoff, and any other exception stops the scan.
+// If the connection fails part way, the registers scanned so far are returned along with the error.
+func (c *Client) ScanRegisters(ctx context.Context, from, to uint16, opts ScanOptions) ([]ScannedRegister, error) {
+	if to < from {
+		return nil, fmt.Errorf("scan range ends before it starts")
+	}
+	if opts.MaxBlock == 0 {
+		opts.MaxBlock = defaultScanBlock
+	}
+	opts.MaxBlock = min(opts.MaxBlock, maxScanBlock)
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	total := int(to) - int(from) + 1
+	results := make([]ScannedRegister, 0, total)
+
+	block := opts.MaxBlock
+	addr := int(from)
+	var lastRead time.Time
+	busyRetries := 0
+	busyBackoff := time.Duration(0)
+
+	for addr <= int(to) {
+		size := uint16(min(int(block), int(to)-addr+1))
+
+		// rate limit, and back off further while the inverter's busy
+		if wait := max(opts.Interval, busyBackoff) - time.Since(lastRead); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return results, ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+		lastRead = time.Now()
+
+		readCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+		b, err := c.conn.ReadHoldingRegistersU16(readCtx, uint16(addr), size)
+		cancel()
+
+		if scanBusy(err) && busyRetries < scanBusyRetries {
+			busyRetries++
+			busyBackoff = max(2*busyBackoff, time.Second)
+			continue
+		}
+		busyRetries, busyBackoff = 0, 0
+
+		var exc *modbus.ModbusException
+		switch {
+		case err == nil:
+			for i := range int(size) {
+				results = append(results, ScannedRegister{Address: uint16(addr + i), Value: b[i*2 : i*2+2]})
+			}
+			addr += int(size)
+			block = min(block*2, opts.MaxBlock)
+
+		// only an illegal address means a register isn't there, anything else would fill the report with false gaps
+		case errors.As(err, &exc) && exc.ExceptionCode == modbus.ExceptionIllegalDataAddress && size > 1:
+			// try again with a smaller block, to narrow down which registers it doesn't like
+			block = size / 2
+			continue
+
+		case errors.As(err, &exc) && exc.ExceptionCode == modbus.ExceptionIllegalDataAddress:
+			results = append(results, ScannedRegister{Address: uint16(addr), Exception: exc})
+			addr++
+
+		default:
+			return results, fmt.Errorf("failed to read %d registers at %d: %w", size, addr, err)
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(len(results), total)
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
+)
+
+// InverterConfig is one connection the agent polls, i.e. an inverter at another site, or another SDongle
+type InverterConfig struct {
+	Name string `yaml:"name"`
+	// Anything left empty falls back to the top level modbus and broadcast sections
+	Modbus    ModbusConfig    `yaml:"modbus"`
+	Broadcast BroadcastConfig `yaml:"broadcast"`
+	// defaults to the top level interval
+	Interval string `yaml:"interval"`
+
+	// defaults to <mqtt.topic>/<name>
+	Topic string `yaml:"topic"`
+	// defaults to <topic>/availability
+	AvailabilityTopic string `yaml:"availability_topic"`
+
+	Devices []DeviceConfig `yaml:"devices"`
+}
+
+// inverter is an InverterConfig with the fallbacks applied. Without any inverters in the config,
+// there's a single unnamed one using the top level settings.
+type inverter struct {
+	name     string
+	modbus   ModbusConfig
+	interval time.Duration
+
+	broadcastDstIP  net.IP
+	broadcastSelfIP net.IP
+
+	topic             string
+	availabilityTopic string
+	alarmsTopic       string
+	infoTopic         string
+	commandsTopic     string
+	resultTopic       string
+
+	registerMap solar.RegisterMap
+	devices     []*device
+}
+
+func parseInverters(cfg *LoadedConfig) error {
+	if len(cfg.Inverters) == 0 {
+		inv := &inverter{
+			modbus:            cfg.Modbus,
+			interval:          cfg.interval,
+			topic:             cfg.MQTT.Topic,
+			availabilityTopic: cfg.MQTT.InverterAvailabilityTopic,
+			alarmsTopic:       cfg.MQTT.AlarmsTopic,
+			infoTopic:         cfg.MQTT.InfoTopic,
+			commandsTopic:     cfg.Commands.Topic,
+			resultTopic:       cfg.Commands.ResultTopic,
+		}
+		err := parseInverter(cfg, inv, cfg.Broadcast, cfg.Devices)
+		if err != nil {
+			return err
+		}
+		cfg.inverters = []*inverter{inv}
+		return nil
+	}
+
+	if len(cfg.Devices) > 0 {
+		return fmt.Errorf("devices must be listed under their inverter when inverters are set")
+	}
+
+	seen := make(map[string]bool)
+	for i, ic := range cfg.Inverters {
+		if ic.Name == "" || strings.ContainsAny(ic.Name, "/+#") {
+			return fmt.Errorf("inverter %d: name must be set, and can't contain '/', '+' or '#'", i)
+		}
+		if seen[ic.Name] {
+			return fmt.Errorf("inverter %d: duplicate name %q", i, ic.Name)
+		}
+		seen[ic.Name] = true
+
+		inv := &inverter{
+			name:              ic.Name,
+			modbus:            mergeModbusConfig(ic.Modbus, cfg.Modbus),
+			interval:          cfg.interval,
+			topic:             ic.Topic,
+			availabilityTopic: ic.AvailabilityTopic,
+			commandsTopic:     cfg.Commands.Topic + "/" + ic.Name,
+			resultTopic:       cfg.Commands.ResultTopic + "/" + ic.Name,
+		}
+		if inv.topic == "" {
+			inv.topic = cfg.MQTT.Topic + "/" + ic.Name
+		}
+		if inv.availabilityTopic == "" {
+			inv.availabilityTopic = inv.topic + "/availability"
+		}
+		inv.alarmsTopic = inv.topic + "/alarms"
+		inv.infoTopic = inv.topic + "/info"
+
+		if ic.Interval != "" {
+			d, err := time.ParseDuration(ic.Interval)
+			if err != nil {
+				return fmt.Errorf("inverter %q: invalid interval %q: %v", ic.Name, ic.Interval, err)
+			}
+			inv.interval = d
+		}
+
+		broadcast := ic.Broadcast
+		if broadcast.DestinationIP == "" {
+			broadcast.DestinationIP = cfg.Broadcast.DestinationIP
+		}
+		if broadcast.SelfIP == "" {
+			broadcast.SelfIP = cfg.Broadcast.SelfIP
+		}
+
+		err := parseInverter(cfg, inv, broadcast, ic.Devices)
+		if err != nil {
+			return fmt.Errorf("inverter %q: %v", ic.Name, err)
+		}
+		cfg.inverters = append(cfg.inverters, inv)
+	}
+
+	return nil
+}
+
+// parseInverter resolves the parts that are the same whether or not the inverter is named
+func parseInverter(cfg *LoadedConfig, inv *inverter, broadcast BroadcastConfig, devices []DeviceConfig) error {
+	if broadcast.DestinationIP == "" {
+		broadcast.DestinationIP = "255.255.255.255"
+	}
+	inv.broadcastDstIP = net.ParseIP(broadcast.DestinationIP)
+	inv.broadcastSelfIP = net.ParseIP(broadcast.SelfIP)
+	if inv.broadcastDstIP == nil || inv.broadcastSelfIP == nil {
+		return fmt.Errorf("invalid broadcast ip %q; %q", broadcast.DestinationIP, broadcast.SelfIP)
+	}
+
+	if inv.modbus.RegisterMap != "" {
+		m, err := solar.LoadRegisterMap(inv.modbus.RegisterMap)
+		if err != nil {
+			return fmt.Errorf("invalid register map %q: %v", inv.modbus.RegisterMap, err)
+		}
+		inv.registerMap = m
+	}
+
+	err := parseDevices(inv, devices)
+	if err != nil {
+		return err
+	}
+
+	for _, dev := range inv.devices {
+		if cfg.deviceByName(dev.name) != nil {
+			return fmt.Errorf("device name %q is already used by another inverter", dev.name)
+		}
+		cfg.devices = append(cfg.devices, dev)
+	}
+	return nil
+}
+
+// mergeModbusConfig fills in anything the inverter didn't set from the top level modbus section
+func mergeModbusConfig(mc, fallback ModbusConfig) ModbusConfig {
+	if mc.IP == "" {
+		mc.IP = fallback.IP
+	}
+	if mc.Port == 0 {
+		mc.Port = fallback.Port
+	}
+	if mc.SlaveID == nil {
+		mc.SlaveID = fallback.SlaveID
+	}
+	if mc.Username == "" {
+		mc.Username = fallback.Username
+	}
+	if mc.Password == "" {
+		mc.Password = fallback.Password
+	}
+	if mc.ReadGap == nil {
+		mc.ReadGap = fallback.ReadGap
+	}
+	if mc.SkipInverterVerify == nil {
+		mc.SkipInverterVerify = fallback.SkipInverterVerify
+	}
+	if mc.RegisterMap == "" {
+		mc.RegisterMap = fallback.RegisterMap
+	}
+	return mc
+}
+
+// inverterByName picks the inverter for a CLI command, which can be left out if there's only one
+func (cfg *LoadedConfig) inverterByName(name string) (*inverter, error) {
+	if name == "" {
+		if len(cfg.inverters) > 1 {
+			return nil, fmt.Errorf("the config has %d inverters, pick one with -inverter", len(cfg.inverters))
+		}
+		return cfg.inverters[0], nil
+	}
+
+	for _, inv := range cfg.inverters {
+		if inv.name == name {
+			return inv, nil
+		}
+	}
+	return nil, fmt.Errorf("no inverter named %q", name)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseInverters(t *testing.T) {
+	t.Run("top level only", func(t *testing.T) {
+		cfg, err := parseTestConfig(t, testConfigBase+"mqtt: {topic: solar}\n")
+		if err != nil {
+			t.Fatalf("parseConfig() error: %v", err)
+		}
+		if len(cfg.inverters) != 1 || len(cfg.devices) != 1 {
+			t.Fatalf("got %d inverters and %d devices, want 1 of each", len(cfg.inverters), len(cfg.devices))
+		}
+		inv := cfg.inverters[0]
+		if inv.name != "" || inv.topic != "solar" || inv.availabilityTopic != "solar/inverter/availability" || inv.commandsTopic != "solar/set" {
+			t.Errorf("inverter = %+v", inv)
+		}
+		if inv.broadcastDstIP.String() != "255.255.255.255" {
+			t.Errorf("broadcast destination = %v, want the default", inv.broadcastDstIP)
+		}
+	})
+
+	t.Run("inverters", func(t *testing.T) {
+		cfg, err := parseTestConfig(t, `
+modbus: {ip: 10.0.0.1, port: 502, slave_id: 1, username: user, password: pw}
+broadcast: {self_ip: 10.0.0.2}
+mqtt: {topic: solar}
+interval: 30s
+inverters:
+  - name: house
+  - name: shed
+    modbus: {ip: 10.0.0.5, slave_id: 0}
+    broadcast: {destination_ip: 10.0.0.255}
+    interval: 1m
+    topic: shed/pv
+    devices:
+      - {name: shed_inverter, unit_id: 0}
+      - {name: shed_meter, unit_id: 11}
+`)
+		if err != nil {
+			t.Fatalf("parseConfig() error: %v", err)
+		}
+		if len(cfg.inverters) != 2 || len(cfg.devices) != 3 {
+			t.Fatalf("got %d inverters and %d devices, want 2 and 3", len(cfg.inverters), len(cfg.devices))
+		}
+
+		house := cfg.inverters[0]
+		if house.modbus.IP != "10.0.0.1" || house.modbus.slaveID() != 1 || house.modbus.Password != "pw" || house.interval != 30*time.Second {
+			t.Errorf("house = %+v", house)
+		}
+		if house.topic != "solar/house" || house.availabilityTopic != "solar/house/availability" || house.commandsTopic != "solar/set/house" {
+			t.Errorf("house topics = %q, %q, %q", house.topic, house.availabilityTopic, house.commandsTopic)
+		}
+
+		shed := cfg.inverters[1]
+		// slave_id 0 is set, so doesn't fall back to 1
+		if shed.modbus.IP != "10.0.0.5" || shed.modbus.Port != 502 || shed.modbus.slaveID() != 0 || shed.interval != time.Minute {
+			t.Errorf("shed = %+v", shed)
+		}
+		if shed.broadcastDstIP.String() != "10.0.0.255" || shed.broadcastSelfIP.String() != "10.0.0.2" {
+			t.Errorf("shed broadcast = %v, %v", shed.broadcastDstIP, shed.broadcastSelfIP)
+		}
+		if shed.topic != "shed/pv" || shed.devices[1].topic != "shed/pv/shed_meter" || shed.devices[1].commandsTopic != "solar/set/shed/shed_meter" {
+			t.Errorf("shed topics = %q, %q, %q", shed.topic, shed.devices[1].topic, shed.devices[1].commandsTopic)
+		}
+	})
+
+	invalid := []struct {
+		name string
+		yaml string
+	}{
+		{name: "no name", yaml: "inverters: [{modbus: {ip: 10.0.0.5}}]\n"},
+		{name: "wildcard in name", yaml: "inverters: [{name: '#'}]\n"},
+		{name: "duplicate name", yaml: "inverters: [{name: a}, {name: a}]\n"},
+		{name: "bad interval", yaml: "inverters: [{name: a, interval: soon}]\n"},
+		{name: "top level devices", yaml: "inverters: [{name: a}]\ndevices: [{name: meter, unit_id: 0}]\n"},
+		{name: "device name used twice", yaml: "inverters: [{name: a, devices: [{name: meter, unit_id: 0}]}, {name: b, devices: [{name: meter, unit_id: 0}]}]\n"},
+		{name: "bad broadcast ip", yaml: "inverters: [{name: a, broadcast: {self_ip: nope}}]\n"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseTestConfig(t, testConfigBase+tt.yaml); err == nil {
+				t.Errorf("parseConfig() should fail")
+			}
+		})
+	}
+}
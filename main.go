@@ -5,6 +5,11 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
 )
 
 func main() {
@@ -23,13 +28,96 @@ func main() {
 		cfgPath := fs.String("config", "config.yaml", "Path to YAML config file")
 		_ = fs.Parse(os.Args[2:])
 
-		cfg, err := loadConfig(*cfgPath)
-		if err != nil {
-			slog.Error("load config", "err", err)
-			os.Exit(1)
+		runAgent(mustLoadConfig(*cfgPath))
+	case "mock":
+		fs := flag.NewFlagSet("mock", flag.ExitOnError)
+		var opts mockOptions
+		fs.StringVar(&opts.listen, "listen", ":6607", "Address to serve Modbus TCP on")
+		fs.StringVar(&opts.helloListen, "hello", ":6600", "UDP address to answer hello broadcasts on, empty to disable")
+		fs.StringVar(&opts.registersPath, "registers", "", "Path to YAML register map, defaults to a built-in SUN2000 snapshot")
+		fs.StringVar(&opts.username, "username", "user", "Login username")
+		fs.StringVar(&opts.password, "password", "", "Login password")
+		fs.BoolVar(&opts.requireLogin, "require-login", false, "Reject register access until logged in")
+		fs.Func("units", "Comma separated unit IDs to answer as, defaults to all", func(v string) error {
+			for _, part := range strings.Split(v, ",") {
+				id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 8)
+				if err != nil {
+					return fmt.Errorf("invalid unit id %q", part)
+				}
+				opts.unitIDs = append(opts.unitIDs, uint8(id))
+			}
+			return nil
+		})
+		_ = fs.Parse(os.Args[2:])
+
+		runMock(opts)
+	case "power-on", "power-off":
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		var opts cliOptions
+		opts.register(fs)
+		timeout := fs.Duration("timeout", 3*time.Minute, "How long to wait for the device status to change")
+		noWait := fs.Bool("no-wait", false, "Send the command without waiting for the device status to change")
+		_ = fs.Parse(os.Args[2:])
+
+		runPower(mustLoadConfig(opts.configPath), opts, cmd == "power-on", *timeout, !*noWait)
+	case "read", "write", "dump", "info", "query":
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		var opts cliOptions
+		opts.register(fs)
+		_ = fs.Parse(os.Args[2:])
+
+		cfg := mustLoadConfig(opts.configPath)
+		switch cmd {
+		case "read":
+			runRead(cfg, opts, fs.Args())
+		case "write":
+			runWrite(cfg, opts, fs.Args())
+		case "dump":
+			runDump(cfg, opts, fs.Args())
+		case "info":
+			runInfo(cfg, opts)
+		case "query":
+			runQuery(cfg, opts)
+		}
+	case "scan":
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		var opts scanOptions
+		opts.register(fs)
+		fs.UintVar(&opts.block, "block", 64, "Most registers to read at once, up to 125")
+		fs.Float64Var(&opts.rate, "rate", 5, "Most reads per second")
+		fs.StringVar(&opts.output, "o", "", "File to write the report to, defaults to stdout")
+		fs.StringVar(&opts.format, "format", "", "csv or json, defaults to json for a .json -o file, otherwise csv")
+		_ = fs.Parse(os.Args[2:])
+
+		runScan(mustLoadConfig(opts.configPath), opts, fs.Args())
+	case "discover":
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		var opts discoverOptions
+		fs.Func("interface", "Interface to broadcast on, can be repeated. Defaults to every interface that can broadcast", func(v string) error {
+			opts.interfaces = append(opts.interfaces, v)
+			return nil
+		})
+		fs.StringVar(&opts.dest, "dest", "", "Address to send the hello to instead, i.e. the broadcast address of another subnet")
+		fs.StringVar(&opts.self, "self", "", "Our IP for the inverter to reply to, needed with -dest")
+		fs.IntVar(&opts.helloPort, "hello-port", solar.HelloPort, "UDP port inverters listen for the hello on")
+		fs.DurationVar(&opts.wait, "wait", 5*time.Second, "How long to collect replies for")
+		ports := fs.String("ports", "502,6606,6607", "Comma separated Modbus TCP ports to probe on each inverter that replies")
+		fs.IntVar(&opts.unit, "unit", 1, "Unit ID to probe with")
+		fs.BoolVar(&opts.json, "json", false, "Print the results as JSON")
+		_ = fs.Parse(os.Args[2:])
+
+		for _, part := range strings.Split(*ports, ",") {
+			port, err := strconv.ParseUint(strings.TrimSpace(part), 10, 16)
+			if err != nil {
+				exitUsage(fmt.Sprintf("invalid port %q", part))
+			}
+			opts.ports = append(opts.ports, int(port))
+		}
+		if opts.unit < 0 || opts.unit > 255 {
+			exitUsage(fmt.Sprintf("invalid unit id %d", opts.unit))
 		}
 
-		runAgent(cfg)
+		runDiscover(opts)
 	case "help", "-h", "--help":
 		printUsage()
 	default:
@@ -39,7 +127,26 @@ func main() {
 	}
 }
 
+func mustLoadConfig(path string) *LoadedConfig {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		slog.Error("load config", "err", err)
+		os.Exit(1)
+	}
+	return cfg
+}
+
 func printUsage() {
 	fmt.Println("Usage:")
 	fmt.Println("  solar-agent agent -config config.yaml")
+	fmt.Println("  solar-agent power-on -config config.yaml [-inverter name] [-timeout 3m] [-no-wait]")
+	fmt.Println("  solar-agent power-off -config config.yaml [-inverter name] [-timeout 3m] [-no-wait]")
+	fmt.Println("  solar-agent read -config config.yaml [-inverter name] [-unit id] <addr> <type> [count]")
+	fmt.Println("  solar-agent write -config config.yaml [-inverter name] [-unit id] <addr> <type> <value>")
+	fmt.Println("  solar-agent dump -config config.yaml [-inverter name] [-unit id] <from> <to>")
+	fmt.Println("  solar-agent info -config config.yaml [-inverter name] [-unit id]")
+	fmt.Println("  solar-agent query -config config.yaml [-inverter name] [-unit id]")
+	fmt.Println("  solar-agent scan -config config.yaml [-inverter name] [-unit id] [-rate 5] [-block 64] [-o report.csv] <from> <to>")
+	fmt.Println("  solar-agent discover [-interface eth0] [-dest ip -self ip] [-wait 5s] [-ports 502,6606,6607] [-json]")
+	fmt.Println("  solar-agent mock [-listen :6607] [-hello :6600] [-registers registers.yaml] [-username user] [-password pw] [-units 0,1,2]")
 }
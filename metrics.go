@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "huawei_solar"
+
+type agentMetrics struct {
+	registry *prometheus.Registry
+	data     *dataCollector
+
+	queryDuration  *prometheus.HistogramVec
+	queryErrors    *prometheus.CounterVec
+	reconnects     *prometheus.CounterVec
+	loginAttempts  *prometheus.CounterVec
+	droppedSamples *prometheus.CounterVec
+	sinkErrors     *prometheus.CounterVec
+}
+
+func newAgentMetrics() *agentMetrics {
+	m := &agentMetrics{
+		registry: prometheus.NewRegistry(),
+		data:     &dataCollector{},
+
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "query_duration_seconds",
+			Help:      "Time taken to query the inverter, including failed queries, by inverter.",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 15},
+		}, []string{"inverter"}),
+		queryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "query_errors_total",
+			Help:      "Number of failed inverter queries, by inverter.",
+		}, []string{"inverter"}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "reconnects_total",
+			Help:      "Number of attempts to re-establish the connection to the inverter, by inverter.",
+		}, []string{"inverter"}),
+		loginAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "login_attempts_total",
+			Help:      "Number of inverter login attempts, by inverter and result.",
+		}, []string{"inverter", "result"}),
+		droppedSamples: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dropped_samples_total",
+			Help:      "Number of samples dropped because a sink wasn't keeping up, by sink.",
+		}, []string{"sink"}),
+		sinkErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "sink_errors_total",
+			Help:      "Number of samples a sink failed to write, by sink.",
+		}, []string{"sink"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.data,
+		m.queryDuration,
+		m.queryErrors,
+		m.reconnects,
+		m.loginAttempts,
+		m.droppedSamples,
+		m.sinkErrors,
+	)
+
+	return m
+}
+
+func (m *agentMetrics) observeLogin(inv *inverter, err error) {
+	if err != nil {
+		m.loginAttempts.WithLabelValues(inv.name, "failure").Inc()
+	} else {
+		m.loginAttempts.WithLabelValues(inv.name, "success").Inc()
+	}
+}
+
+func (m *agentMetrics) serve(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	slog.Info("serving prometheus metrics", "addr", addr)
+	err := srv.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("metrics server", "err", err)
+	}
+}
+
+// dataCollector exposes the latest sample from each device. Every numeric field becomes a gauge named after its json key.
+type dataCollector struct {
+	mu   sync.Mutex
+	last map[string]*solar.Sample
+}
+
+func (c *dataCollector) update(d *solar.Sample) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last == nil {
+		c.last = make(map[string]*solar.Sample)
+	}
+	c.last[d.Device] = d
+}
+
+// Unchecked collector, as the set of metrics depends on the sample
+func (c *dataCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *dataCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	samples := slices.Collect(maps.Values(c.last))
+	c.mu.Unlock()
+
+	for _, d := range samples {
+		c.collectSample(ch, d)
+	}
+}
+
+func (c *dataCollector) collectSample(ch chan<- prometheus.Metric, d *solar.Sample) {
+	// device is empty (so left out) unless there's more than one
+	labels := prometheus.Labels{"model": d.ModelName(), "serial": d.SerialNumber(), "device": d.Device}
+
+	for _, field := range d.Fields {
+		if field.Key == "device_status" {
+			continue
+		}
+
+		value, ok := field.Number()
+		if !ok {
+			continue
+		}
+
+		desc := prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", field.Key), "Inverter telemetry field "+field.Key+".", nil, labels)
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+
+	c.collectAlarms(ch, d, labels)
+
+	status, ok := d.DeviceStatus()
+	if !ok {
+		return
+	}
+
+	codeDesc := prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "device_status_code"), "Raw inverter device status code.", nil, labels)
+	ch <- prometheus.MustNewConstMetric(codeDesc, prometheus.GaugeValue, float64(status))
+
+	// enum style, 1 for the current status and 0 for the rest
+	statusDesc := prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "device_status"), "Inverter device status, 1 for the current status.", []string{"status"}, labels)
+	current := solar.StatusText(status)
+	seen := false
+	for _, status := range solar.StatusTexts() {
+		value := 0.0
+		if status == current {
+			value = 1
+			seen = true
+		}
+		ch <- prometheus.MustNewConstMetric(statusDesc, prometheus.GaugeValue, value, status)
+	}
+	if !seen {
+		ch <- prometheus.MustNewConstMetric(statusDesc, prometheus.GaugeValue, 1, current)
+	}
+}
+
+// enum style again, 1 for each active alarm and 0 for the rest of the known alarms
+func (c *dataCollector) collectAlarms(ch chan<- prometheus.Metric, d *solar.Sample, labels prometheus.Labels) {
+	active, ok := d.Alarms()
+	if !ok {
+		return
+	}
+
+	desc := prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "alarm_active"), "Inverter alarm, 1 if currently active.", []string{"id", "name", "severity"}, labels)
+	for _, alarm := range solar.KnownAlarms() {
+		value := 0.0
+		if slices.Contains(active, alarm) {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, strconv.Itoa(int(alarm.ID)), alarm.Name, alarm.Severity)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/mock"
+	"golang.org/x/sync/errgroup"
+)
+
+type mockOptions struct {
+	listen        string
+	helloListen   string
+	registersPath string
+	username      string
+	password      string
+	requireLogin  bool
+	unitIDs       []uint8
+}
+
+func runMock(opts mockOptions) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := mock.NewServer()
+	srv.Username = opts.username
+	srv.Password = opts.password
+	srv.RequireLogin = opts.requireLogin
+	srv.UnitIDs = opts.unitIDs
+
+	regs := mock.DefaultRegisters()
+	if opts.registersPath != "" {
+		var err error
+		regs, err = mock.LoadRegisters(opts.registersPath)
+		if err != nil {
+			slog.Error("load mock registers", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	err := srv.SetRegisters(regs)
+	if err != nil {
+		slog.Error("set mock registers", "err", err)
+		os.Exit(1)
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+
+	g.Go(func() error {
+		return srv.ListenAndServe(ctx, opts.listen)
+	})
+
+	if opts.helloListen != "" {
+		g.Go(func() error {
+			return srv.ListenAndServeHello(ctx, opts.helloListen)
+		})
+	}
+
+	err = g.Wait()
+	if err != nil && ctx.Err() == nil {
+		slog.Error("mock inverter", "err", err)
+		os.Exit(1)
+	}
+	slog.Info("exiting")
+}
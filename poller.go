@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/modbus"
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
+)
+
+// How long to wait after the inverter rejects the username/password before trying it again
+const loginRejectedBackoff = 10 * time.Minute
+
+// poller owns the connection to one inverter, and queries each of its devices every interval.
+// Each inverter gets its own, so one that's unreachable doesn't hold up the others.
+type poller struct {
+	cfg     *LoadedConfig
+	inv     *inverter
+	mc      mqtt.Client
+	avail   *availability
+	metrics *agentMetrics
+	sinks   []*bufferedSink
+	cmdCh   <-chan commandRequest
+	log     *slog.Logger
+
+	client *solar.Client
+	// set when the inverter rejects the username/password (so it isn't retried, and the account locked, every query),
+	// or can't prove it knows them
+	loginRejected      error
+	loginRejectedUntil time.Time
+	// outlive reconnects, so a bad read straight after reconnecting is still caught
+	counters map[string]*solar.CounterGuard
+}
+
+func newPoller(cfg *LoadedConfig, inv *inverter, mc mqtt.Client, avail *availability, metrics *agentMetrics, sinks []*bufferedSink, cmdCh <-chan commandRequest) *poller {
+	p := &poller{
+		cfg:      cfg,
+		inv:      inv,
+		mc:       mc,
+		avail:    avail,
+		metrics:  metrics,
+		sinks:    sinks,
+		cmdCh:    cmdCh,
+		log:      slog.Default(),
+		counters: make(map[string]*solar.CounterGuard),
+	}
+	if inv.name != "" {
+		p.log = p.log.With("inverter", inv.name)
+	}
+	for _, dev := range inv.devices {
+		p.counters[dev.name] = solar.NewCounterGuard()
+	}
+	return p
+}
+
+// supervise runs the poller until ctx is done, restarting it if it panics,
+// so a bug tripped by one inverter doesn't take the others down with it
+func (p *poller) supervise(ctx context.Context) {
+	for ctx.Err() == nil {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					p.log.Error("inverter poller panicked, restarting it", "panic", r, "stack", string(debug.Stack()))
+				}
+			}()
+			p.run(ctx)
+		}()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+func (p *poller) run(ctx context.Context) {
+	defer p.disconnect()
+
+	err := p.connect(ctx)
+	if err != nil {
+		p.log.Warn("failed to connect to inverter, retrying", "err", err)
+		if !p.reconnect(ctx) {
+			return
+		}
+	}
+	p.login(ctx)
+
+	ticker := time.NewTicker(p.inv.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case req := <-p.cmdCh:
+			if p.unverified() {
+				publishCommandResult(p.mc, p.cfg, req.dev, req.name, fmt.Errorf("not sending commands to an inverter that couldn't be verified: %w", p.loginRejected))
+				continue
+			}
+			unit := p.client.ForUnit(req.dev.unitID)
+			err := runCommand(ctx, p.cfg, unit, req)
+			if confirm, ok := commandConfirms[req.name]; ok && err == nil {
+				go func() {
+					err := confirm(ctx, p.cfg, unit)
+					p.finishCommand(req, err)
+				}()
+				continue
+			}
+			p.finishCommand(req, err)
+
+		case <-ticker.C:
+			p.queryAll(ctx)
+		}
+	}
+}
+
+func (p *poller) finishCommand(req commandRequest, err error) {
+	if err != nil {
+		p.log.Warn("command failed", "command", req.name, "device", req.dev.name, "err", err)
+	}
+	publishCommandResult(p.mc, p.cfg, req.dev, req.name, err)
+}
+
+func (p *poller) queryAll(ctx context.Context) {
+	// don't publish anything from something that couldn't prove it's the inverter
+	if p.unverified() {
+		if p.loginBackingOff() {
+			return
+		}
+		p.login(ctx)
+		if p.unverified() {
+			return
+		}
+	}
+
+	for _, dev := range p.inv.devices {
+		if p.cfg.LogQuery {
+			p.log.Info("querying...", "device", dev.name)
+		}
+
+		queryStart := time.Now()
+		d, err := querySample(ctx, dev, p.client.ForUnit(dev.unitID))
+		p.metrics.queryDuration.WithLabelValues(p.inv.name).Observe(time.Since(queryStart).Seconds())
+
+		if modbus.IsException(err, modbus.ExceptionGatewayPathFailed) || modbus.IsException(err, modbus.ExceptionGatewayTargetFailed) {
+			// the dongle answered, just not for this device, so the connection's fine
+			p.log.Warn("device not responding", "device", dev.name, "unit_id", dev.unitID, "err", err)
+			p.metrics.queryErrors.WithLabelValues(p.inv.name).Inc()
+			continue
+		}
+		if err != nil {
+			// the rest of the devices are on the same connection, so will wait for the next tick
+			p.handleQueryError(ctx, err)
+			return
+		}
+
+		d.Device = dev.name
+		p.counters[dev.name].Apply(d)
+
+		p.avail.setInverterOnline(p.inv, true)
+		p.metrics.data.update(d)
+
+		if p.cfg.LogQuery {
+			p.log.Info("query data", "device", dev.name, "data", d.Pretty())
+		}
+
+		for _, sink := range p.sinks {
+			sink.offer(d)
+		}
+	}
+}
+
+func (p *poller) handleQueryError(ctx context.Context, err error) {
+	if p.loginBackingOff() {
+		// the rejection was already logged, and logging in again is what would fix it, so wait for the backoff quietly
+		p.log.Debug("query error while waiting to retry login", "err", err, "retry_at", p.loginRejectedUntil.Format(time.TimeOnly))
+		return
+	}
+
+	p.log.Warn("query error", "err", err)
+	p.metrics.queryErrors.WithLabelValues(p.inv.name).Inc()
+	p.log.Info("attempting to login again (likely timed out)")
+
+	err = p.tryLogin(ctx)
+	if err == nil {
+		p.log.Info("successfully logged in again")
+		publishDeviceInfos(ctx, p.mc, p.cfg, p.inv, p.client)
+		return
+	}
+	if solar.IsLoginCredentialsError(err) {
+		// reconnecting won't help
+		p.log.Error("inverter rejected the login, check modbus username and password", "err", err)
+		p.avail.setInverterOnline(p.inv, false)
+		return
+	}
+	if errors.Is(err, solar.ErrLoginInverterNotVerified) {
+		p.logUnverified(err)
+		p.avail.setInverterOnline(p.inv, false)
+		return
+	}
+
+	p.log.Warn("failed to complete login again, restarting connection to inverter", "err", err)
+	p.avail.setInverterOnline(p.inv, false)
+
+	if p.reconnect(ctx) {
+		p.login(ctx)
+	}
+}
+
+func (p *poller) login(ctx context.Context) {
+	err := p.tryLogin(ctx)
+	if solar.IsLoginCredentialsError(err) {
+		p.log.Error("inverter rejected the login, check modbus username and password, proceeding anyway", "err", err)
+		return
+	}
+	if errors.Is(err, solar.ErrLoginInverterNotVerified) {
+		p.logUnverified(err)
+		return
+	}
+	if err != nil {
+		p.log.Warn("problem when trying to log in to inverter, proceeding anyway", "err", err)
+		return
+	}
+	p.log.Info("successfully logged in")
+	publishDeviceInfos(ctx, p.mc, p.cfg, p.inv, p.client)
+}
+
+// unverified is true when the last login couldn't verify the inverter, so nothing should be read from or sent to it
+func (p *poller) unverified() bool {
+	return errors.Is(p.loginRejected, solar.ErrLoginInverterNotVerified)
+}
+
+func (p *poller) logUnverified(err error) {
+	p.log.Error("inverter couldn't prove it knows the password, it may be something else pretending to be it. Not polling it until it can (set modbus.skip_inverter_verify if its firmware answers logins differently)", "ip", p.inv.modbus.IP, "err", err)
+}
+
+// loginBackingOff is true while waiting to retry credentials the inverter rejected
+func (p *poller) loginBackingOff() bool {
+	return p.loginRejected != nil && time.Now().Before(p.loginRejectedUntil)
+}
+
+// tryLogin logs in, unless the credentials were rejected recently
+func (p *poller) tryLogin(ctx context.Context) error {
+	if p.loginBackingOff() {
+		return fmt.Errorf("%w, not trying again until %s", p.loginRejected, p.loginRejectedUntil.Format(time.TimeOnly))
+	}
+
+	err := p.client.Login(ctx, p.inv.modbus.Username, p.inv.modbus.Password)
+	p.metrics.observeLogin(p.inv, err)
+
+	p.loginRejected = nil
+	if solar.IsLoginCredentialsError(err) || errors.Is(err, solar.ErrLoginInverterNotVerified) {
+		p.loginRejected = err
+		p.loginRejectedUntil = time.Now().Add(loginRejectedBackoff)
+	}
+	return err
+}
+
+// reconnect keeps trying to connect, backing off up to every 5 minutes. It only gives up if ctx is done.
+func (p *poller) reconnect(ctx context.Context) bool {
+	p.metrics.reconnects.WithLabelValues(p.inv.name).Inc()
+	err := p.connect(ctx)
+	backoff := time.Second
+
+	attempts := 0
+	for err != nil {
+		p.log.Warn("failed to connect to inverter", "err", err, "attempts", attempts, "retrying_in", backoff.Seconds())
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		p.metrics.reconnects.WithLabelValues(p.inv.name).Inc()
+		err = p.connect(ctx)
+
+		if backoff < (5 * time.Minute) {
+			attempts++
+			if attempts >= 10 {
+				backoff *= 2
+				attempts = 0
+			}
+		}
+	}
+	return true
+}
+
+func (p *poller) connect(ctx context.Context) error {
+	p.disconnect()
+
+	dialCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	client, err := setupInverter(dialCtx, p.inv)
+	if err != nil {
+		return err
+	}
+
+	err = client.BroadcastHello(p.inv.broadcastDstIP, p.inv.broadcastSelfIP)
+	if err != nil {
+		p.log.Warn("problem when trying to broadcast hello message, proceeding anyway (normal when across VLANs/subnets)", "err", err)
+	}
+
+	p.client = client
+	go client.Run(ctx)
+	return nil
+}
+
+func (p *poller) disconnect() {
+	if p.client != nil {
+		p.client.Close()
+		p.client = nil
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
+)
+
+type scanOptions struct {
+	cliOptions
+	block  uint
+	rate   float64
+	output string
+	format string
+}
+
+// scanRow is one register in the report. The decodings are only set if the register responded.
+type scanRow struct {
+	Address   uint16 `json:"address"`
+	Responded bool   `json:"responded"`
+	Exception string `json:"exception,omitempty"`
+	*registerDecodings
+}
+
+func runScan(cfg *LoadedConfig, opts scanOptions, args []string) {
+	if len(args) != 2 {
+		exitUsage("scan needs <from> <to>")
+	}
+	from, err := parseAddress(args[0])
+	if err != nil {
+		exitUsage(err.Error())
+	}
+	to, err := parseAddress(args[1])
+	if err != nil {
+		exitUsage(err.Error())
+	}
+	if to < from {
+		exitUsage("scan range ends before it starts")
+	}
+	if opts.rate <= 0 {
+		exitUsage("-rate must be more than 0")
+	}
+
+	if opts.format == "" {
+		opts.format = "csv"
+		if filepath.Ext(opts.output) == ".json" {
+			opts.format = "json"
+		}
+	}
+	if opts.format != "csv" && opts.format != "json" {
+		exitUsage(fmt.Sprintf("unknown format %q, expected csv or json", opts.format))
+	}
+
+	runCLI(cfg, opts.cliOptions, func(ctx context.Context, inverter *solar.Client) error {
+		lastLog := time.Now()
+		scanned, scanErr := inverter.ScanRegisters(ctx, from, to, solar.ScanOptions{
+			MaxBlock: uint16(min(opts.block, 125)),
+			Interval: time.Duration(float64(time.Second) / opts.rate),
+			Progress: func(done, total int) {
+				if time.Since(lastLog) > 10*time.Second {
+					slog.Info("scanning", "done", done, "total", total)
+					lastLog = time.Now()
+				}
+			},
+		})
+		if scanErr != nil && len(scanned) == 0 {
+			return scanErr
+		}
+
+		// write what we've got even if the scan failed part way, it's likely been running a while
+		err := writeScanReport(opts, scanned)
+		if err != nil {
+			return err
+		}
+
+		responded := 0
+		for _, reg := range scanned {
+			if reg.Value != nil {
+				responded++
+			}
+		}
+		slog.Info("scan finished", "scanned", len(scanned), "responded", responded)
+
+		if scanErr != nil {
+			return fmt.Errorf("scan stopped at %d: %w", int(from)+len(scanned), scanErr)
+		}
+		return nil
+	})
+}
+
+func writeScanReport(opts scanOptions, scanned []solar.ScannedRegister) error {
+	regs := make([][]byte, len(scanned))
+	for i, reg := range scanned {
+		regs[i] = reg.Value
+	}
+
+	rows := make([]scanRow, len(scanned))
+	for i, reg := range scanned {
+		rows[i] = scanRow{Address: reg.Address, Responded: reg.Value != nil}
+		if reg.Value != nil {
+			d := decodeRegisterAt(regs, i)
+			rows[i].registerDecodings = &d
+		}
+		if reg.Exception != nil {
+			rows[i].Exception = reg.Exception.ExceptionCode.String()
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if opts.output != "" {
+		f, err := os.Create(opts.output)
+		if err != nil {
+			return fmt.Errorf("failed to create report: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if opts.format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"address", "responded", "exception", "hex", "u16", "i16", "u32", "i32", "ascii"})
+	for _, row := range rows {
+		record := []string{strconv.Itoa(int(row.Address)), strconv.FormatBool(row.Responded), row.Exception, "", "", "", "", "", ""}
+		if d := row.registerDecodings; d != nil {
+			copy(record[3:], []string{d.Hex, d.U16, d.I16, d.U32, d.I32, d.ASCII})
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
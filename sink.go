@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync/atomic"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
+)
+
+// Sink is somewhere samples are sent, i.e. MQTT, a file, a webhook
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, d *solar.Sample) error
+	Close() error
+}
+
+// bufferedSink gives each sink its own queue and goroutine, so a slow sink doesn't hold up the others
+type bufferedSink struct {
+	sink    Sink
+	ch      chan *solar.Sample
+	metrics *agentMetrics
+}
+
+func newBufferedSink(sink Sink, size int, metrics *agentMetrics) *bufferedSink {
+	return &bufferedSink{
+		sink:    sink,
+		ch:      make(chan *solar.Sample, size),
+		metrics: metrics,
+	}
+}
+
+// offer queues the sample without blocking, dropping it if the sink is behind
+func (b *bufferedSink) offer(d *solar.Sample) {
+	select {
+	case b.ch <- d:
+	default:
+		b.metrics.droppedSamples.WithLabelValues(b.sink.Name()).Inc()
+		slog.Warn("sink buffer full, dropping sample", "sink", b.sink.Name())
+	}
+}
+
+func (b *bufferedSink) run(ctx context.Context) {
+	defer b.sink.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case d := <-b.ch:
+			err := b.sink.Write(ctx, d)
+			if err != nil {
+				b.metrics.sinkErrors.WithLabelValues(b.sink.Name()).Inc()
+				slog.Warn("sink write error", "sink", b.sink.Name(), "err", err)
+			}
+		}
+	}
+}
+
+func setupSinks(cfg *LoadedConfig, mc mqtt.Client, haDiscoveryGen *atomic.Uint64, metrics *agentMetrics) ([]*bufferedSink, error) {
+	sinks := []*bufferedSink{}
+
+	for i, sc := range cfg.Sinks {
+		var sink Sink
+		var err error
+
+		switch sc.Type {
+		case "mqtt":
+			if mc == nil {
+				err = fmt.Errorf("mqtt.broker must be set for the mqtt sink")
+				break
+			}
+			sink = newMqttSink(cfg, mc, haDiscoveryGen)
+		case "stdout":
+			sink = newStdoutSink()
+		case "file":
+			sink, err = newFileSink(sc.Path)
+		case "webhook":
+			sink = newWebhookSink(sc)
+		case "influxdb":
+			sink, err = newInfluxSink(sc)
+		default:
+			err = fmt.Errorf("unknown type %q", sc.Type)
+		}
+
+		if err != nil {
+			for _, s := range sinks {
+				s.sink.Close()
+			}
+			return nil, fmt.Errorf("sink %d: %v", i, err)
+		}
+		sinks = append(sinks, newBufferedSink(sink, sc.Buffer, metrics))
+	}
+
+	return sinks, nil
+}
+
+// redactedURL is a sink url that's safe to use in names, which end up in logs and metric labels,
+// so without any userinfo or query string (which often hold tokens)
+func redactedURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "invalid url"
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
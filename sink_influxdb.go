@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
+)
+
+// 4xx responses mean the data itself is bad
+var errInfluxRejected = errors.New("influxdb rejected the points")
+
+// influxSink writes line protocol, either POSTed to a /api/v2/write (or 1.x /write) compatible endpoint, or over UDP.
+// Lines are batched, and kept on failure to be retried with the next batch.
+type influxSink struct {
+	sc SinkConfig
+
+	client  *http.Client
+	udpAddr string
+
+	pending []string
+}
+
+func newInfluxSink(sc SinkConfig) (*influxSink, error) {
+	u, err := url.Parse(sc.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %q: %v", sc.URL, err)
+	}
+
+	s := &influxSink{sc: sc}
+	switch u.Scheme {
+	case "http", "https":
+		s.client = &http.Client{Timeout: sc.timeout}
+	case "udp":
+		s.udpAddr = u.Host
+	default:
+		return nil, fmt.Errorf("unsupported url scheme %q, must be http, https or udp", u.Scheme)
+	}
+	return s, nil
+}
+
+func (s *influxSink) Name() string {
+	return "influxdb:" + redactedURL(s.sc.URL)
+}
+
+func (s *influxSink) Write(ctx context.Context, d *solar.Sample) error {
+	line, ok := influxLine(s.sc, d)
+	if !ok {
+		slog.Debug("sample has no fields influxdb can store, skipping it")
+		return nil
+	}
+	s.pending = append(s.pending, line)
+
+	if len(s.pending) > s.sc.MaxPending {
+		dropped := len(s.pending) - s.sc.MaxPending
+		s.pending = s.pending[dropped:]
+		slog.Warn("influxdb backlog full, dropping oldest points", "dropped", dropped)
+	}
+
+	if len(s.pending) < s.sc.BatchSize {
+		return nil
+	}
+
+	// send the backlog a batch at a time, so a rejected batch doesn't take the rest of it down too
+	var rejected int
+	var rejectedErr error
+	for len(s.pending) > 0 {
+		batch := s.pending[:min(len(s.pending), s.sc.BatchSize)]
+
+		var err error
+		if s.client != nil {
+			err = s.writeHTTP(ctx, batch)
+		} else {
+			err = s.writeUDP(batch)
+		}
+		if errors.Is(err, errInfluxRejected) {
+			// retrying won't make bad data good
+			rejected += len(batch)
+			rejectedErr = err
+		} else if err != nil {
+			return fmt.Errorf("failed to write %d points, will retry: %v", len(s.pending), err)
+		}
+
+		s.pending = slices.Delete(s.pending, 0, len(batch))
+	}
+
+	if rejectedErr != nil {
+		return fmt.Errorf("dropped %d points: %w", rejected, rejectedErr)
+	}
+	return nil
+}
+
+func (s *influxSink) writeHTTP(ctx context.Context, lines []string) error {
+	body := strings.Join(lines, "\n")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.sc.URL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.sc.Token != "" {
+		req.Header.Set("Authorization", "Token "+s.sc.Token)
+	}
+	for k, v := range s.sc.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode >= 400 && resp.StatusCode <= 499 && resp.StatusCode != http.StatusTooManyRequests {
+		return fmt.Errorf("%w with status %d: %s", errInfluxRejected, resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("influxdb responded with status %d: %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+	return nil
+}
+
+// One datagram per line, so we never have to worry about the MTU
+func (s *influxSink) writeUDP(lines []string) error {
+	conn, err := net.Dial("udp", s.udpAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, line := range lines {
+		_, err := conn.Write([]byte(line))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *influxSink) Close() error {
+	if len(s.pending) > 0 {
+		slog.Warn("influxdb sink closing with unsent points", "points", len(s.pending))
+	}
+	if s.client != nil {
+		s.client.CloseIdleConnections()
+	}
+	return nil
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	influxStringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// influxLine encodes a sample as a single line of line protocol, with nanosecond precision.
+// Returns false if there are no fields left to write, as a line without any isn't valid.
+func influxLine(sc SinkConfig, d *solar.Sample) (string, bool) {
+	var b strings.Builder
+
+	b.WriteString(influxMeasurementEscaper.Replace(sc.Measurement))
+
+	tags := maps.Clone(sc.Tags)
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	if serial := d.SerialNumber(); serial != "" {
+		tags[sc.SerialTag] = serial
+	}
+	if model := d.ModelName(); model != "" {
+		tags[sc.ModelTag] = model
+	}
+	if d.Device != "" {
+		tags["device"] = d.Device
+	}
+	// line protocol wants tags sorted by key
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		if tags[k] == "" {
+			continue
+		}
+		fmt.Fprintf(&b, ",%s=%s", influxTagEscaper.Replace(k), influxTagEscaper.Replace(tags[k]))
+	}
+
+	sep := " "
+	for _, field := range d.Fields {
+		fv := reflect.ValueOf(field.Value)
+
+		var value string
+		switch {
+		case field.Key == "serial_number" || field.Key == "model_name":
+			continue
+		case field.IsString():
+			value = `"` + influxStringEscaper.Replace(fv.String()) + `"`
+		case fv.CanInt():
+			value = strconv.FormatInt(fv.Int(), 10) + "i"
+		case fv.CanUint():
+			value = strconv.FormatUint(fv.Uint(), 10) + "i"
+		case fv.CanFloat():
+			// line protocol has no way to write NaN or Inf
+			if math.IsNaN(fv.Float()) || math.IsInf(fv.Float(), 0) {
+				continue
+			}
+			value = strconv.FormatFloat(fv.Float(), 'f', -1, 64)
+		default:
+			continue
+		}
+
+		b.WriteString(sep)
+		b.WriteString(influxTagEscaper.Replace(field.Key))
+		b.WriteString("=")
+		b.WriteString(value)
+		sep = ","
+	}
+
+	if sep == " " {
+		return "", false
+	}
+
+	fmt.Fprintf(&b, " %d", d.Timestamp.UnixNano())
+	return b.String(), true
+}
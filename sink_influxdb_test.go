@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
+)
+
+func TestInfluxLine(t *testing.T) {
+	sc := SinkConfig{Measurement: "solar power", SerialTag: "serial", ModelTag: "model", Tags: map[string]string{"site": "home,1"}}
+	ts := time.Unix(1700000000, 5)
+
+	tests := []struct {
+		name   string
+		fields []solar.Field
+		device string
+		want   string
+		wantOK bool
+	}{
+		{
+			name: "types and tags",
+			fields: []solar.Field{
+				{Key: "model_name", Value: "SUN2000"},
+				{Key: "serial_number", Value: "HV123"},
+				{Key: "pv_power", Value: 1500.5},
+				{Key: "alarm_1", Value: uint16(3)},
+				{Key: "temp", Value: int16(-2)},
+				{Key: "status text", Value: `say "hi"`},
+			},
+			device: "meter",
+			want:   `solar\ power,device=meter,model=SUN2000,serial=HV123,site=home\,1 pv_power=1500.5,alarm_1=3i,temp=-2i,status\ text="say \"hi\"" 1700000000000000005`,
+			wantOK: true,
+		},
+		{
+			name:   "non finite floats are skipped",
+			fields: []solar.Field{{Key: "a", Value: math.NaN()}, {Key: "b", Value: 1.0}, {Key: "c", Value: math.Inf(-1)}},
+			want:   `solar\ power,site=home\,1 b=1 1700000000000000005`,
+			wantOK: true,
+		},
+		{
+			name:   "no fields left",
+			fields: []solar.Field{{Key: "serial_number", Value: "HV123"}, {Key: "a", Value: math.NaN()}, {Key: "alarms", Value: []solar.Alarm{}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := influxLine(sc, &solar.Sample{Timestamp: ts, Device: tt.device, Fields: tt.fields})
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("influxLine() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestInfluxSinkRejectedBatch(t *testing.T) {
+	var written []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "bad=") {
+			http.Error(w, "partial write", http.StatusBadRequest)
+			return
+		}
+		written = append(written, strings.Split(string(body), "\n")...)
+	}))
+	defer srv.Close()
+
+	// writes fail until the server's up, building a backlog of three batches, the middle one bad
+	sc := SinkConfig{URL: "http://127.0.0.1:1/write", Measurement: "m", BatchSize: 2, MaxPending: 100, timeout: time.Second}
+	s, err := newInfluxSink(sc)
+	if err != nil {
+		t.Fatalf("newInfluxSink() error: %v", err)
+	}
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "bad", "c", "d"} {
+		s.Write(ctx, &solar.Sample{Fields: []solar.Field{{Key: key, Value: 1.0}}})
+	}
+	if len(s.pending) != 5 {
+		t.Fatalf("pending = %d, want 5 while the server is down", len(s.pending))
+	}
+
+	s.sc.URL = srv.URL
+	err = s.Write(ctx, &solar.Sample{Fields: []solar.Field{{Key: "e", Value: 1.0}}})
+	if !errors.Is(err, errInfluxRejected) {
+		t.Errorf("Write() error = %v, want the rejection", err)
+	}
+	if len(s.pending) != 0 {
+		t.Errorf("pending = %d, want 0", len(s.pending))
+	}
+	if len(written) != 4 {
+		t.Errorf("written = %q, want the 4 points outside the rejected batch", written)
+	}
+}
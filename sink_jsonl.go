@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
+)
+
+// jsonLinesSink writes one JSON object per line, to stdout or a file
+type jsonLinesSink struct {
+	name string
+	w    io.Writer
+	c    io.Closer
+
+	mu sync.Mutex
+}
+
+func newStdoutSink() *jsonLinesSink {
+	return &jsonLinesSink{name: "stdout", w: os.Stdout}
+}
+
+func newFileSink(path string) (*jsonLinesSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonLinesSink{name: "file:" + path, w: f, c: f}, nil
+}
+
+func (s *jsonLinesSink) Name() string {
+	return s.name
+}
+
+func (s *jsonLinesSink) Write(ctx context.Context, d *solar.Sample) error {
+	line, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+func (s *jsonLinesSink) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	return s.c.Close()
+}
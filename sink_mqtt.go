@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/lachlan2k/huawei-solar-mqtt-relay/internal/solar"
+)
+
+type mqttSink struct {
+	cfg *LoadedConfig
+	mc  mqtt.Client
+
+	// compared against haDiscovered, to re-send discovery per device after the broker reconnects
+	haDiscoveryGen *atomic.Uint64
+	haDiscovered   map[string]uint64
+	// fields each device's discovery was sent with, as some (i.e. energy counters) only show up after the first sample
+	haDiscoveredFields map[string]map[string]bool
+
+	// last published alarms per device, missing until its first sample
+	lastAlarms map[string][]solar.Alarm
+}
+
+func newMqttSink(cfg *LoadedConfig, mc mqtt.Client, haDiscoveryGen *atomic.Uint64) *mqttSink {
+	return &mqttSink{
+		cfg:            cfg,
+		mc:             mc,
+		haDiscoveryGen: haDiscoveryGen,
+		haDiscovered:   make(map[string]uint64),
+		lastAlarms:     make(map[string][]solar.Alarm),
+
+		haDiscoveredFields: make(map[string]map[string]bool),
+	}
+}
+
+func (s *mqttSink) Name() string {
+	return "mqtt"
+}
+
+func (s *mqttSink) Write(ctx context.Context, d *solar.Sample) error {
+	dev := s.cfg.deviceByName(d.Device)
+	if dev == nil {
+		return fmt.Errorf("sample from unknown device %q", d.Device)
+	}
+
+	gen := s.haDiscoveryGen.Load()
+	if s.cfg.HomeAssistant.Enabled && (s.haDiscovered[dev.name] != gen || s.hasNewFields(dev, d)) {
+		err := publishHADiscovery(s.mc, s.cfg, dev, d)
+		if err != nil {
+			return fmt.Errorf("failed to publish home assistant discovery: %v", err)
+		}
+		if s.haDiscovered[dev.name] != gen || s.haDiscoveredFields[dev.name] == nil {
+			s.haDiscoveredFields[dev.name] = make(map[string]bool)
+		}
+		s.haDiscovered[dev.name] = gen
+		for _, f := range d.Fields {
+			s.haDiscoveredFields[dev.name][f.Key] = true
+		}
+	}
+
+	payload, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("marshal error when sending mqtt json: %v", err)
+	}
+
+	token := s.mc.Publish(dev.topic, s.cfg.MQTT.QoS, s.cfg.MQTT.Retain, payload)
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		return fmt.Errorf("mqtt publish error: %v", token.Error())
+	}
+
+	return s.publishAlarms(dev, d)
+}
+
+// hasNewFields is whether the sample has a field that wasn't in the device's last discovery
+func (s *mqttSink) hasNewFields(dev *device, d *solar.Sample) bool {
+	for _, f := range d.Fields {
+		if !s.haDiscoveredFields[dev.name][f.Key] {
+			return true
+		}
+	}
+	return false
+}
+
+// publishAlarms publishes the active alarms retained, only if they changed
+func (s *mqttSink) publishAlarms(dev *device, d *solar.Sample) error {
+	alarms, ok := d.Alarms()
+	if !ok {
+		return nil
+	}
+	last, published := s.lastAlarms[dev.name]
+	if published && slices.Equal(alarms, last) {
+		return nil
+	}
+
+	payload, err := json.Marshal(alarms)
+	if err != nil {
+		return fmt.Errorf("marshal error when sending mqtt alarms: %v", err)
+	}
+
+	token := s.mc.Publish(dev.alarmsTopic, s.cfg.MQTT.QoS, true, payload)
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		return fmt.Errorf("mqtt publish alarms error: %v", token.Error())
+	}
+
+	slog.Info("active alarms changed", "device", dev.name, "alarms", len(alarms))
+	s.lastAlarms[dev.name] = alarms
+	return nil
+}
+
+// The client is shared with the rest of the agent, which disconnects it
+func (s *mqttSink) Close() error {
+	return nil
+}